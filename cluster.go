@@ -0,0 +1,74 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+)
+
+/*
+ * One exporter can collect several Slurm clusters. The collectors of each
+ * cluster are run by their own SlurmCollector, its metrics carry a cluster
+ * label, and the Slurm commands are executed with -M <cluster> or with the
+ * slurm.conf of the cluster. A failing cluster only affects its own series.
+ */
+
+// Cluster collected by the exporter
+type Cluster struct {
+	Name string `yaml:"name"`
+	// slurm.conf of the cluster, without one the commands are run with -M
+	SlurmConf string `yaml:"slurm_conf"`
+}
+
+type clusterKey struct{}
+
+// Returns a context executing the Slurm commands for the cluster
+func withCluster(ctx context.Context, cluster *Cluster) context.Context {
+	if cluster == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clusterKey{}, cluster)
+}
+
+// Returns the cluster of the context, nil for the local cluster
+func clusterFromContext(ctx context.Context) *Cluster {
+	cluster, _ := ctx.Value(clusterKey{}).(*Cluster)
+	return cluster
+}
+
+// Value of the cluster label, empty for the local cluster
+func (c *Cluster) label() string {
+	if c == nil {
+		return ""
+	}
+	return c.Name
+}
+
+// Arguments of a Slurm command run for the cluster
+func (c *Cluster) args(args []string) []string {
+	if c == nil || c.SlurmConf != "" {
+		return args
+	}
+	return append([]string{"-M", c.Name}, args...)
+}
+
+// Environment of a Slurm command run for the cluster
+func (c *Cluster) env() []string {
+	if c == nil || c.SlurmConf == "" {
+		return nil
+	}
+	return []string{"SLURM_CONF=" + c.SlurmConf}
+}
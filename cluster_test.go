@@ -0,0 +1,108 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Serves the test data to cluster a, the commands of other clusters fail
+type clusterRunner struct{}
+
+func (clusterRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if len(args) < 2 || args[0] != "-M" || args[1] != "a" {
+		return nil, &CommandError{Command: name, Args: args, ExitCode: 1, Err: exec.ErrNotFound}
+	}
+	return fakeRunner{"squeue": "test_data/squeue.txt", "sdiag": "test_data/sdiag.txt"}.Run(ctx, name, args[2:]...)
+}
+
+func TestClusters(t *testing.T) {
+	useRunner(t, clusterRunner{})
+	registry := prometheus.NewRegistry()
+	if err := registerCollectors(collectContext, registry, []string{"queue", "scheduler"}, []Cluster{{Name: "a"}, {Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	success := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			cluster := ""
+			collector := ""
+			for _, l := range m.Label {
+				switch l.GetName() {
+				case "cluster":
+					cluster = l.GetValue()
+				case "collector":
+					collector = l.GetValue()
+				}
+			}
+			if cluster == "" {
+				t.Errorf("Metric %s has no cluster label", mf.GetName())
+			}
+			if mf.GetName() == "slurm_exporter_collector_success" {
+				success[cluster+"/"+collector] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if success["a/queue"] != 1 || success["a/scheduler"] != 1 {
+		t.Errorf("Expected the collectors of cluster a to succeed, got %v", success)
+	}
+	if v, ok := success["b/queue"]; !ok || v != 0 {
+		t.Errorf("Expected the collectors of cluster b to fail, got %v", success)
+	}
+}
+
+func TestClusterCommand(t *testing.T) {
+	c := &Cluster{Name: "a"}
+	if args := c.args([]string{"show", "node"}); len(args) != 4 || args[0] != "-M" || args[1] != "a" {
+		t.Errorf("Expected -M a, got %v", args)
+	}
+	c.SlurmConf = "/etc/slurm/a.conf"
+	if args := c.args([]string{"show", "node"}); len(args) != 2 {
+		t.Errorf("Expected no -M with a slurm.conf, got %v", args)
+	}
+	if env := c.env(); len(env) != 1 || env[0] != "SLURM_CONF=/etc/slurm/a.conf" {
+		t.Errorf("Unexpected environment %v", env)
+	}
+	out, err := NewExecRunner(0).Run(withCluster(context.Background(), c), "sh", "-c", "echo $SLURM_CONF")
+	if err != nil || string(out) != "/etc/slurm/a.conf\n" {
+		t.Errorf("Expected SLURM_CONF to be set, got %q: %v", out, err)
+	}
+}
+
+func TestCollectErrorsByCluster(t *testing.T) {
+	useRunner(t, fakeRunner{})
+	before := testutil.ToFloat64(collectErrors.WithLabelValues("scheduler", "vc7"))
+	registry := prometheus.NewRegistry()
+	if err := registerCollectors(context.Background(), registry, []string{"scheduler"}, []Cluster{{Name: "vc7"}}); err != nil {
+		t.Fatal(err)
+	}
+	registry.Gather()
+	if after := testutil.ToFloat64(collectErrors.WithLabelValues("scheduler", "vc7")); after != before+1 {
+		t.Errorf("Expected the failure to be counted for vc7, got %v", after-before)
+	}
+	if testutil.ToFloat64(collectErrors.WithLabelValues("scheduler", "milton")) != 0 {
+		t.Errorf("Expected no failure for milton")
+	}
+}
@@ -0,0 +1,303 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ * Every collector registers itself by name from the init() function of
+ * its source file. Each one can be switched on and off from the command
+ * line with --collector.<name> and --no-collector.<name>.
+ */
+
+// Collector is implemented by all Slurm collectors, Update returns an
+// error if the Slurm data could not be read
+type Collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error
+}
+
+// ScrapeData holds the Slurm data shared by all collectors of a single
+// scrape, it is read on first use and only once
+type ScrapeData struct {
+	ctx       context.Context
+	jobsOnce  sync.Once
+	jobs      []Job
+	jobsErr   error
+	nodesOnce sync.Once
+	nodes     []Node
+	nodesErr  error
+	diagOnce  sync.Once
+	diag      *SchedulerMetrics
+	diagErr   error
+}
+
+// Jobs returns the jobs of the data source
+func (d *ScrapeData) Jobs() ([]Job, error) {
+	d.jobsOnce.Do(func() {
+		d.jobs, d.jobsErr = source.Jobs(d.ctx)
+	})
+	return d.jobs, d.jobsErr
+}
+
+// Nodes returns the node inventory of the data source
+func (d *ScrapeData) Nodes() ([]Node, error) {
+	d.nodesOnce.Do(func() {
+		d.nodes, d.nodesErr = source.Nodes(d.ctx)
+	})
+	return d.nodes, d.nodesErr
+}
+
+// Diag returns the scheduler statistics of the data source
+func (d *ScrapeData) Diag() (*SchedulerMetrics, error) {
+	d.diagOnce.Do(func() {
+		d.diag, d.diagErr = source.Diag(d.ctx)
+	})
+	return d.diag, d.diagErr
+}
+
+type collectorEntry struct {
+	enabled        bool
+	defaultEnabled bool
+	factory        func() Collector
+}
+
+var collectors = map[string]*collectorEntry{}
+
+// Add a collector to the registry and define its command line flags
+func registerCollector(name string, enabled bool, factory func() Collector) {
+	entry := &collectorEntry{enabled: enabled, defaultEnabled: enabled, factory: factory}
+	collectors[name] = entry
+	flag.Var(&collectorFlag{state: &entry.enabled},
+		"collector."+name,
+		fmt.Sprintf("Enable the %s collector.", name))
+	flag.Var(&collectorFlag{state: &entry.enabled, negate: true},
+		"no-collector."+name,
+		fmt.Sprintf("Disable the %s collector.", name))
+}
+
+// Names of all enabled collectors in alphabetical order
+func enabledCollectors() []string {
+	names := []string{}
+	for name, entry := range collectors {
+		if entry.enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create all enabled collectors for each cluster and register them
+func registerEnabledCollectors(registerer prometheus.Registerer, clusters []Cluster) error {
+	return registerCollectors(collectContext, registerer, enabledCollectors(), clusters)
+}
+
+// Create the named collectors for each cluster and register them, the
+// metrics of a cluster have a cluster label. Without clusters the local
+// cluster is collected without label. The collections end with ctx.
+func registerCollectors(ctx context.Context, registerer prometheus.Registerer, names []string, clusters []Cluster) error {
+	if len(clusters) == 0 {
+		sc := NewSlurmCollector(names)
+		sc.ctx = ctx
+		if err := registerer.Register(sc); err != nil {
+			return fmt.Errorf("can not register collectors: %v", err)
+		}
+		return nil
+	}
+	for i := range clusters {
+		sc := NewSlurmCollector(names)
+		sc.ctx = ctx
+		sc.cluster = &clusters[i]
+		r := prometheus.WrapRegistererWith(prometheus.Labels{"cluster": clusters[i].Name}, registerer)
+		if err := r.Register(sc); err != nil {
+			return fmt.Errorf("can not register collectors of cluster %s: %v", clusters[i].Name, err)
+		}
+	}
+	return nil
+}
+
+/*
+ * The SlurmCollector runs the selected collectors in parallel and
+ * reports the duration and result of each one.
+ */
+
+// Parent context of all collections, cancelled on shutdown to stop the
+// running Slurm commands
+var collectContext, cancelCollections = context.WithCancel(context.Background())
+
+var (
+	collectorDuration = prometheus.NewDesc(
+		"slurm_exporter_collector_duration_seconds",
+		"Time a collector took to read and export its metrics",
+		[]string{"collector"},
+		nil)
+	collectorSuccess = prometheus.NewDesc(
+		"slurm_exporter_collector_success",
+		"Whether a collector succeeded, 1 on success and 0 on failure",
+		[]string{"collector"},
+		nil)
+)
+
+// SlurmCollector implements the Prometheus Collector interface for a set
+// of Slurm collectors
+type SlurmCollector struct {
+	collectors map[string]Collector
+	cluster    *Cluster        // nil for the local cluster
+	ctx        context.Context // parent of the collections
+}
+
+// NewSlurmCollector creates the named collectors
+func NewSlurmCollector(names []string) *SlurmCollector {
+	sc := &SlurmCollector{collectors: map[string]Collector{}, ctx: collectContext}
+	for _, name := range names {
+		sc.collectors[name] = collectors[name].factory()
+	}
+	return sc
+}
+
+func (sc *SlurmCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collectorDuration
+	ch <- collectorSuccess
+	for _, c := range sc.collectors {
+		c.Describe(ch)
+	}
+}
+
+func (sc *SlurmCollector) Collect(ch chan<- prometheus.Metric) {
+	scrape := &ScrapeData{ctx: withCluster(sc.ctx, sc.cluster)}
+	wg := sync.WaitGroup{}
+	wg.Add(len(sc.collectors))
+	for name, c := range sc.collectors {
+		go func(name string, c Collector) {
+			sc.execute(name, c, ch, scrape)
+			wg.Done()
+		}(name, c)
+	}
+	wg.Wait()
+}
+
+func (sc *SlurmCollector) execute(name string, c Collector, ch chan<- prometheus.Metric, scrape *ScrapeData) {
+	start := time.Now()
+	err := c.Update(ch, scrape)
+	duration := time.Since(start)
+	success := 1.0
+	if err != nil {
+		reportCollectError(name, sc.cluster, err)
+		if scrape.ctx.Err() == context.DeadlineExceeded {
+			collectTimeouts.WithLabelValues(name, sc.cluster.label()).Inc()
+		}
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(collectorDuration, prometheus.GaugeValue, duration.Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(collectorSuccess, prometheus.GaugeValue, success, name)
+}
+
+// Boolean flag setting the state of a collector, negate is used by the
+// --no-collector.<name> variant
+type collectorFlag struct {
+	state  *bool
+	negate bool
+}
+
+func (f *collectorFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *collectorFlag) String() string {
+	if f.state == nil {
+		return ""
+	}
+	return strconv.FormatBool(*f.state != f.negate)
+}
+
+func (f *collectorFlag) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f.state = v != f.negate
+	return nil
+}
+
+// Failed collections, the collector skips its metrics instead of exiting
+var collectErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "slurm_exporter_collect_errors_total",
+		Help: "Number of collections which failed because a Slurm command could not be executed",
+	},
+	[]string{"collector", "cluster"})
+
+func reportCollectError(collector string, cluster *Cluster, err error) {
+	if cluster != nil {
+		level.Error(logger).Log("msg", "Collector failed", "collector", collector, "cluster", cluster.Name, "err", err)
+	} else {
+		level.Error(logger).Log("msg", "Collector failed", "collector", collector, "err", err)
+	}
+	collectErrors.WithLabelValues(collector, cluster.label()).Inc()
+}
+
+// Collections which did not finish before the scrape timeout
+var collectTimeouts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "slurm_exporter_collect_timeouts_total",
+		Help: "Number of collections which failed because the scrape timeout expired",
+	},
+	[]string{"collector", "cluster"})
+
+// Values in the Slurm output which could not be parsed, by parser function
+var parseErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "slurm_exporter_parse_errors_total",
+		Help: "Number of values in the output of Slurm commands which could not be parsed",
+	},
+	[]string{"parser"})
+
+// Parse a number from the Slurm output, a failure is counted for the
+// parser, logged and returns zero. Slurm prints N/A for values it does not know,
+// e.g. the CPU load of a node which is down, that is not an error.
+func parseFloat(parser string, s string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "N/A" {
+		return 0
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		parseErrors.WithLabelValues(parser).Inc()
+		level.Warn(logger).Log("msg", "Can not parse a number in the Slurm output", "parser", parser, "value", s)
+		return 0
+	}
+	return v
+}
+
+// Count and log a line of Slurm output which does not have the expected
+// format
+func parseError(parser string, line string) {
+	parseErrors.WithLabelValues(parser).Inc()
+	level.Warn(logger).Log("msg", "Unexpected line in the Slurm output", "parser", parser, "line", line)
+}
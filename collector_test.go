@@ -0,0 +1,134 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectorFlags(t *testing.T) {
+	state := map[string]bool{}
+	for name, entry := range collectors {
+		state[name] = entry.enabled
+	}
+	defer func() {
+		for name, enabled := range state {
+			collectors[name].enabled = enabled
+		}
+	}()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for name, entry := range collectors {
+		fs.Var(&collectorFlag{state: &entry.enabled}, "collector."+name, "")
+		fs.Var(&collectorFlag{state: &entry.enabled, negate: true}, "no-collector."+name, "")
+	}
+	if err := fs.Parse([]string{"--no-collector.users", "--collector.cpusinfo", "-collector.queue=false"}); err != nil {
+		t.Fatal(err)
+	}
+	if collectors["users"].enabled || collectors["queue"].enabled {
+		t.Errorf("Expected the users and queue collectors to be disabled")
+	}
+	if !collectors["cpusinfo"].enabled || !collectors["nodes"].enabled {
+		t.Errorf("Expected the cpusinfo and nodes collectors to be enabled")
+	}
+}
+
+func TestRegisterEnabledCollectors(t *testing.T) {
+	for _, entry := range collectors {
+		if !entry.enabled {
+			entry.enabled = true
+			defer func(e *collectorEntry) { e.enabled = false }(entry)
+		}
+	}
+	// all collectors together must not describe any metric twice
+	if err := registerEnabledCollectors(prometheus.NewRegistry(), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlurmCollectorSuccess(t *testing.T) {
+	useRunner(t, fakeRunner{"sdiag": "test_data/sdiag.txt"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector([]string{"queue", "scheduler"}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	success := map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "slurm_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			success[m.Label[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	if success["scheduler"] != 1 {
+		t.Errorf("Expected the scheduler collector to succeed")
+	}
+	if v, ok := success["queue"]; !ok || v != 0 {
+		t.Errorf("Expected the queue collector to fail without squeue")
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	before := testutil.ToFloat64(parseErrors.WithLabelValues("TestParseFloat"))
+	if v := parseFloat("TestParseFloat", " 42 "); v != 42 {
+		t.Errorf("Expected 42, got %v", v)
+	}
+	if v := parseFloat("TestParseFloat", "N/A"); v != 0 {
+		t.Errorf("Expected 0 for N/A, got %v", v)
+	}
+	parseFloat("TestParseFloat", "42G")
+	if after := testutil.ToFloat64(parseErrors.WithLabelValues("TestParseFloat")); after != before+1 {
+		t.Errorf("Expected one parse error, got %v", after-before)
+	}
+}
+
+// Counts the executions of each command
+type countingRunner struct {
+	runner CommandRunner
+	mu     sync.Mutex
+	count  map[string]int
+}
+
+func (r *countingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	r.count[name]++
+	r.mu.Unlock()
+	return r.runner.Run(ctx, name, args...)
+}
+
+func TestJobsReadOncePerScrape(t *testing.T) {
+	r := &countingRunner{runner: fakeRunner{"squeue": "test_data/squeue.txt"}, count: map[string]int{}}
+	useRunner(t, r)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector([]string{"accounts", "queue", "users"}))
+	for i := 1; i <= 2; i++ {
+		if _, err := registry.Gather(); err != nil {
+			t.Fatal(err)
+		}
+		if r.count["squeue"] != i {
+			t.Errorf("Expected squeue to run once per scrape, got %d runs after %d scrapes", r.count["squeue"], i)
+		}
+	}
+}
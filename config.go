@@ -0,0 +1,199 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+ * The exporter can be configured with a YAML file given by --config.file,
+ * values set on the command line take precedence over the file. The file
+ * is validated at startup, the exporter does not start with an invalid
+ * configuration.
+ */
+
+// Config of the exporter as read from the YAML file
+type Config struct {
+	// Collectors switched on (true) or off (false)
+	Collectors map[string]bool `yaml:"collectors"`
+	// Path of slurm.conf passed to the Slurm commands as SLURM_CONF
+	SlurmConf string `yaml:"slurm_conf"`
+	// Path and timeout of each Slurm command
+	Commands map[string]CommandConfig `yaml:"commands"`
+	// Default timeout of the Slurm commands
+	CommandTimeout time.Duration `yaml:"command_timeout"`
+	// Refresh interval of the metrics in polling mode, 0 to collect on scrape
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Static labels added to all Slurm metrics
+	ExtraLabels map[string]string `yaml:"extra_labels"`
+	// Series are dropped unless their label values pass the filters
+	LabelFilters []LabelFilter `yaml:"label_filters"`
+	// Maximum number of distinct values of a label per metric
+	CardinalityLimits map[string]int `yaml:"cardinality_limits"`
+	// Clusters collected instead of the local one
+	Clusters []Cluster `yaml:"clusters"`
+	// Named sets of collectors for the /probe endpoint
+	Modules map[string][]string `yaml:"modules"`
+}
+
+// CommandConfig of a single Slurm command
+type CommandConfig struct {
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LabelFilter keeps the series whose label value matches Allow and does
+// not match Deny, the regular expressions are anchored
+type LabelFilter struct {
+	Label string `yaml:"label"`
+	Allow string `yaml:"allow"`
+	Deny  string `yaml:"deny"`
+
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+}
+
+// Slurm commands which can be configured
+var slurmCommands = map[string]bool{
+	"sacct":    true,
+	"scontrol": true,
+	"sdiag":    true,
+	"sinfo":    true,
+	"squeue":   true,
+}
+
+// LoadConfig reads and validates the configuration file
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses and validates a YAML configuration, unknown keys
+// are an error
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	for _, name := range sortedKeys(c.Collectors) {
+		if _, ok := collectors[name]; !ok {
+			return fmt.Errorf("collectors: unknown collector %q", name)
+		}
+	}
+	for name, cmd := range c.Commands {
+		if !slurmCommands[name] {
+			return fmt.Errorf("commands: unknown Slurm command %q", name)
+		}
+		if cmd.Timeout < 0 {
+			return fmt.Errorf("commands: %s: negative timeout %s", name, cmd.Timeout)
+		}
+	}
+	if c.CommandTimeout < 0 {
+		return fmt.Errorf("command_timeout: negative timeout %s", c.CommandTimeout)
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("poll_interval: negative interval %s", c.PollInterval)
+	}
+	for name := range c.ExtraLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("extra_labels: invalid label name %q", name)
+		}
+	}
+	for i := range c.LabelFilters {
+		f := &c.LabelFilters[i]
+		if !model.LabelName(f.Label).IsValid() {
+			return fmt.Errorf("label_filters: invalid label name %q", f.Label)
+		}
+		if f.Allow == "" && f.Deny == "" {
+			return fmt.Errorf("label_filters: %s: allow or deny is required", f.Label)
+		}
+		var err error
+		if f.Allow != "" {
+			if f.allow, err = regexp.Compile("^(?:" + f.Allow + ")$"); err != nil {
+				return fmt.Errorf("label_filters: %s: invalid allow expression: %v", f.Label, err)
+			}
+		}
+		if f.Deny != "" {
+			if f.deny, err = regexp.Compile("^(?:" + f.Deny + ")$"); err != nil {
+				return fmt.Errorf("label_filters: %s: invalid deny expression: %v", f.Label, err)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, cluster := range c.Clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("clusters: name is required")
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("clusters: duplicate cluster %q", cluster.Name)
+		}
+		seen[cluster.Name] = true
+	}
+	if _, ok := c.ExtraLabels["cluster"]; ok && len(c.Clusters) > 0 {
+		return fmt.Errorf("extra_labels: the cluster label is set by clusters")
+	}
+	for module, names := range c.Modules {
+		if len(names) == 0 {
+			return fmt.Errorf("modules: %s: no collectors", module)
+		}
+		for _, name := range names {
+			if _, ok := collectors[name]; !ok {
+				return fmt.Errorf("modules: %s: unknown collector %q", module, name)
+			}
+		}
+	}
+	for label, limit := range c.CardinalityLimits {
+		if !model.LabelName(label).IsValid() {
+			return fmt.Errorf("cardinality_limits: invalid label name %q", label)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("cardinality_limits: %s: limit must be positive, got %d", label, limit)
+		}
+	}
+	return nil
+}
+
+// Keys of a map in alphabetical order, for reproducible error messages
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+collectors:
+  users: false
+  cpusinfo: true
+slurm_conf: /etc/slurm/milton.conf
+commands:
+  squeue:
+    path: /opt/slurm/bin/squeue
+    timeout: 1m
+command_timeout: 20s
+poll_interval: 30s
+extra_labels:
+  site: wehi
+label_filters:
+  - label: account
+    deny: "test.*"
+cardinality_limits:
+  user: 100
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Collectors["users"] || !cfg.Collectors["cpusinfo"] {
+		t.Errorf("Unexpected collectors %v", cfg.Collectors)
+	}
+	if cfg.Commands["squeue"].Timeout != time.Minute || cfg.CommandTimeout != 20*time.Second {
+		t.Errorf("Unexpected timeouts %+v", cfg)
+	}
+	if !cfg.LabelFilters[0].deny.MatchString("testing") || cfg.LabelFilters[0].deny.MatchString("mytest") {
+		t.Errorf("Expected the deny expression to be anchored")
+	}
+	r := newExecRunner(cfg)
+	if r.Paths["squeue"] != "/opt/slurm/bin/squeue" || r.Env[0] != "SLURM_CONF=/etc/slurm/milton.conf" {
+		t.Errorf("Unexpected runner %+v", r)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for config, expected := range map[string]string{
+		"collectors: {jobs: true}":                   `unknown collector "jobs"`,
+		"commands: {srun: {path: /bin/srun}}":        `unknown Slurm command "srun"`,
+		"command_timeout: -1s":                       "negative timeout",
+		"listen_address: :8080":                      "not found in type main.Config",
+		"extra_labels: {0site: wehi}":                `invalid label name "0site"`,
+		"label_filters: [{label: user}]":             "allow or deny is required",
+		"label_filters: [{label: user, allow: '('}]": "invalid allow expression",
+		"cardinality_limits: {user: 0}":              "limit must be positive",
+		"poll_interval: often":                       "cannot unmarshal",
+	} {
+		_, err := ParseConfig([]byte(config))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q for %q, got %v", expected, config, err)
+		}
+	}
+}
@@ -17,13 +17,12 @@ package main
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"io/ioutil"
-	"log"
-	"os/exec"
-	"strconv"
-	"strings"
 )
 
+func init() {
+	registerCollector("cpus", true, func() Collector { return NewCPUsCollector() })
+}
+
 type CPUsMetrics struct {
 	alloc float64
 	idle  float64
@@ -31,39 +30,19 @@ type CPUsMetrics struct {
 	total float64
 }
 
-func CPUsGetMetrics() *CPUsMetrics {
-	return ParseCPUsMetrics(CPUsData())
-}
-
-func ParseCPUsMetrics(input []byte) *CPUsMetrics {
+// Sum up the CPUs of all nodes
+func ParseCPUsMetrics(nodes []Node) *CPUsMetrics {
 	var cm CPUsMetrics
-	if strings.Contains(string(input), "/") {
-		splitted := strings.Split(strings.TrimSpace(string(input)), "/")
-		cm.alloc, _ = strconv.ParseFloat(splitted[0], 64)
-		cm.idle, _ = strconv.ParseFloat(splitted[1], 64)
-		cm.other, _ = strconv.ParseFloat(splitted[2], 64)
-		cm.total, _ = strconv.ParseFloat(splitted[3], 64)
+	for _, node := range nodes {
+		alloc, idle, other := node.CPUs()
+		cm.alloc += alloc
+		cm.idle += idle
+		cm.other += other
+		cm.total += node.CPUTotal
 	}
 	return &cm
 }
 
-// Execute the sinfo command and return its output
-func CPUsData() []byte {
-	cmd := exec.Command("sinfo", "-h", "-o %C")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
-}
-
 /*
  * Implement the Prometheus Collector interface and feed the
  * Slurm scheduler metrics into it.
@@ -93,10 +72,15 @@ func (cc *CPUsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- cc.other
 	ch <- cc.total
 }
-func (cc *CPUsCollector) Collect(ch chan<- prometheus.Metric) {
-	cm := CPUsGetMetrics()
+func (cc *CPUsCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	nodes, err := scrape.Nodes()
+	if err != nil {
+		return err
+	}
+	cm := ParseCPUsMetrics(nodes)
 	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
 	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
 	ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm.other)
 	ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, cm.total)
+	return nil
 }
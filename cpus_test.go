@@ -16,21 +16,16 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"os"
 	"testing"
 )
 
 func TestCPUsMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/sinfo_cpus.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
+	cm := ParseCPUsMetrics(readNodes(t))
+	t.Logf("%+v", cm)
+	if cm.total != 2432 {
+		t.Errorf("Expected 2432 CPUs, got %v", cm.total)
+	}
+	if cm.alloc+cm.idle+cm.other != cm.total {
+		t.Errorf("Allocated, idle and other CPUs do not add up to the total: %+v", cm)
 	}
-	data, err := ioutil.ReadAll(file)
-	t.Logf("%+v", ParseCPUsMetrics(data))
-}
-
-func TestCPUssGetMetrics(t *testing.T) {
-	t.Logf("%+v", CPUsGetMetrics())
 }
@@ -16,57 +16,35 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"log"
-	"os/exec"
-	"strconv"
-	"strings"
-
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-type CPUsInfoMetrics struct {
-	alloc   float64
-	idle    float64
-	other   float64
-	total   float64
-	feature string
-}
-
-// CPUsInfoGetMetrics function
-func CPUsInfoGetMetrics() *CPUsInfoMetrics {
-	return ParseCPUsInfoMetrics(CPUsInfoData())
+func init() {
+	registerCollector("cpusinfo", false, func() Collector { return NewCPUsInfoCollector() })
 }
 
-// ParseCPUsInfoMetrics function
-func ParseCPUsInfoMetrics(input []byte) *CPUsInfoMetrics {
-	var cm CPUsInfoMetrics
-	if strings.Contains(string(input), "/") {
-		splitted := strings.Split(strings.TrimSpace(string(input)), "/")
-		cm.alloc, _ = strconv.ParseFloat(splitted[0], 64)
-		cm.idle, _ = strconv.ParseFloat(splitted[1], 64)
-		cm.other, _ = strconv.ParseFloat(splitted[2], 64)
-		cm.total, _ = strconv.ParseFloat(splitted[3], 64)
-		cm.feature = splitted[4]
-	}
-	return &cm
+type CPUsInfoMetrics struct {
+	alloc float64
+	idle  float64
+	other float64
+	total float64
 }
 
-// Execute the sinfo command and return its output
-func CPUsInfoData() []byte {
-	cmd := exec.Command("sinfo", "-h", "-o %C/%f")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
+// ParseCPUsInfoMetrics sums up the CPUs of the nodes by feature
+func ParseCPUsInfoMetrics(nodes []Node) map[string]*CPUsInfoMetrics {
+	features := make(map[string]*CPUsInfoMetrics)
+	for _, node := range nodes {
+		_, key := features[node.Features]
+		if !key {
+			features[node.Features] = &CPUsInfoMetrics{0, 0, 0, 0}
+		}
+		alloc, idle, other := node.CPUs()
+		features[node.Features].alloc += alloc
+		features[node.Features].idle += idle
+		features[node.Features].other += other
+		features[node.Features].total += node.CPUTotal
 	}
-	return out
+	return features
 }
 
 /*
@@ -76,11 +54,12 @@ func CPUsInfoData() []byte {
  */
 
 func NewCPUsInfoCollector() *CPUsInfoCollector {
+	labels := []string{"feature"}
 	return &CPUsInfoCollector{
-		alloc: prometheus.NewDesc("slurm_CPUsInfo_alloc", "Allocated CPUsInfo", nil, nil),
-		idle:  prometheus.NewDesc("slurm_CPUsInfo_idle", "Idle CPUsInfo", nil, nil),
-		other: prometheus.NewDesc("slurm_CPUsInfo_other", "Mix CPUsInfo", nil, nil),
-		total: prometheus.NewDesc("slurm_CPUsInfo_total", "Total CPUsInfo", nil, nil),
+		alloc: prometheus.NewDesc("slurm_CPUsInfo_alloc", "Allocated CPUsInfo", labels, nil),
+		idle:  prometheus.NewDesc("slurm_CPUsInfo_idle", "Idle CPUsInfo", labels, nil),
+		other: prometheus.NewDesc("slurm_CPUsInfo_other", "Mix CPUsInfo", labels, nil),
+		total: prometheus.NewDesc("slurm_CPUsInfo_total", "Total CPUsInfo", labels, nil),
 	}
 }
 
@@ -98,10 +77,17 @@ func (cc *CPUsInfoCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- cc.other
 	ch <- cc.total
 }
-func (cc *CPUsInfoCollector) Collect(ch chan<- prometheus.Metric) {
-	cm := CPUsInfoGetMetrics()
-	ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm.alloc)
-	ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm.idle)
-	ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm.other)
-	ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, cm.total)
+func (cc *CPUsInfoCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	nodes, err := scrape.Nodes()
+	if err != nil {
+		return err
+	}
+	cm := ParseCPUsInfoMetrics(nodes)
+	for f := range cm {
+		ch <- prometheus.MustNewConstMetric(cc.alloc, prometheus.GaugeValue, cm[f].alloc, f)
+		ch <- prometheus.MustNewConstMetric(cc.idle, prometheus.GaugeValue, cm[f].idle, f)
+		ch <- prometheus.MustNewConstMetric(cc.other, prometheus.GaugeValue, cm[f].other, f)
+		ch <- prometheus.MustNewConstMetric(cc.total, prometheus.GaugeValue, cm[f].total, f)
+	}
+	return nil
 }
@@ -0,0 +1,200 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+ * An Exporter serves the Slurm metrics of one configuration. When the
+ * configuration is reloaded a new Exporter replaces the current one, the
+ * HTTP server and its listening socket are kept.
+ */
+
+// Exporter holds the collectors built from a configuration
+type Exporter struct {
+	cfg      *Config
+	names    []string
+	probe    *ProbeHandler
+	snapshot *SnapshotGatherer // nil unless polling
+	running  sync.WaitGroup
+
+	// collections in the background, cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewExporter checks that the named collectors of the clusters of the
+// configuration can be registered, with a positive interval the metrics
+// are polled
+func NewExporter(cfg *Config, names []string, interval time.Duration) (*Exporter, error) {
+	e := &Exporter{
+		cfg:   cfg,
+		names: names,
+	}
+	e.ctx, e.cancel = context.WithCancel(collectContext)
+	e.probe = NewProbeHandler(cfg, e.names)
+	gatherer, err := e.newGatherer(e.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		e.snapshot = NewSnapshotGatherer(gatherer, interval)
+	}
+	return e, nil
+}
+
+// Registry with the enabled collectors, their collections end with ctx
+func (e *Exporter) newGatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	registry := prometheus.NewRegistry()
+	if err := registerCollectors(ctx, prometheus.WrapRegistererWith(e.cfg.ExtraLabels, registry), e.names, e.cfg.Clusters); err != nil {
+		return nil, err
+	}
+	if len(e.cfg.LabelFilters) > 0 || len(e.cfg.CardinalityLimits) > 0 {
+		return NewFilterGatherer(registry, e.cfg.LabelFilters, e.cfg.CardinalityLimits), nil
+	}
+	return registry, nil
+}
+
+// Gatherer of a scrape, the last snapshot when polling
+func (e *Exporter) gatherer(ctx context.Context) (prometheus.Gatherer, error) {
+	if e.snapshot != nil {
+		return e.snapshot, nil
+	}
+	return e.newGatherer(ctx)
+}
+
+// Start polling, or run a first collection which makes the exporter ready
+// before the first scrape
+func (e *Exporter) Start() {
+	e.running.Add(1)
+	if e.snapshot != nil {
+		prometheus.MustRegister(e.snapshot)
+		go func() {
+			defer e.running.Done()
+			e.snapshot.Run(e.ctx.Done())
+		}()
+		return
+	}
+	go func() {
+		defer e.running.Done()
+		if gatherer, err := e.newGatherer(e.ctx); err == nil {
+			gatherer.Gather()
+		}
+	}()
+}
+
+// Stop polling or the first collection and wait until the running Slurm
+// commands are cancelled, so the collections of the old and new Exporter
+// do not overlap on reload
+func (e *Exporter) Stop() {
+	e.cancel()
+	if e.snapshot != nil {
+		prometheus.Unregister(e.snapshot)
+	}
+	e.running.Wait()
+}
+
+// Server serves the endpoints of the current Exporter
+type Server struct {
+	load     func() (*Exporter, error)
+	reloadMu sync.Mutex
+
+	mu       sync.RWMutex
+	exporter *Exporter
+}
+
+// NewServer starts the Exporter, load creates its replacement on every
+// reload
+func NewServer(e *Exporter, load func() (*Exporter, error)) *Server {
+	e.Start()
+	return &Server{load: load, exporter: e}
+}
+
+func (s *Server) current() *Exporter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exporter
+}
+
+// Reload replaces the current Exporter, it is kept if the new one can not
+// be created
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	e, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	old := s.exporter
+	s.exporter = e
+	s.mu.Unlock()
+	old.Stop()
+	e.Start()
+	return nil
+}
+
+// Stop the current Exporter
+func (s *Server) Stop() {
+	s.current().Stop()
+}
+
+// ServeMetrics serves the metrics of the exporter and of the current
+// Exporter, the collections end with the scrape timeout
+func (s *Server) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := scrapeContext(r, *timeoutOffset)
+	defer cancel()
+	gatherer, err := s.current().gatherer(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, gatherer},
+		promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func (s *Server) ServeProbe(w http.ResponseWriter, r *http.Request) {
+	s.current().probe.ServeHTTP(w, r)
+}
+
+func (s *Server) ServeLanding(w http.ResponseWriter, r *http.Request) {
+	s.current().ServeLanding(w, r)
+}
+
+// ServeReload reloads the configuration on POST /-/reload
+func (s *Server) ServeReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		level.Error(logger).Log("msg", "Reloading the configuration failed", "err", err)
+		http.Error(w, "Reloading the configuration failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(logger).Log("msg", "Configuration reloaded")
+}
@@ -0,0 +1,120 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerReload(t *testing.T) {
+	useRunner(t, fakeRunner{"sdiag": "test_data/sdiag.txt"})
+	first, err := NewExporter(&Config{Collectors: map[string]bool{}}, enabledCollectors(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next *Exporter
+	var loadErr error
+	srv := NewServer(first, func() (*Exporter, error) { return next, loadErr })
+	defer srv.Stop()
+	if next, err = NewExporter(&Config{}, enabledCollectors(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeReload(w, httptest.NewRequest("GET", "/-/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	srv.ServeReload(w, httptest.NewRequest("POST", "/-/reload", nil))
+	if w.Code != http.StatusOK || srv.current() != next {
+		t.Errorf("Expected the exporter to be replaced, got status %d", w.Code)
+	}
+	// a failing reload keeps the current exporter
+	loadErr = errors.New("invalid configuration")
+	w = httptest.NewRecorder()
+	srv.ServeReload(w, httptest.NewRequest("POST", "/-/reload", nil))
+	if w.Code != http.StatusInternalServerError || srv.current() != next {
+		t.Errorf("Expected the exporter to be kept, got status %d", w.Code)
+	}
+}
+
+func TestConfigSettingsDefaults(t *testing.T) {
+	defer func() { configSettings(&Config{}).apply() }()
+	configSettings(&Config{Collectors: map[string]bool{"users": false, "cpusinfo": true}}).apply()
+	if collectors["users"].enabled || !collectors["cpusinfo"].enabled {
+		t.Errorf("Expected the configuration to switch the collectors")
+	}
+	// settings removed from the configuration return to their defaults
+	configSettings(&Config{}).apply()
+	if !collectors["users"].enabled || collectors["cpusinfo"].enabled {
+		t.Errorf("Expected the collectors to return to their defaults")
+	}
+}
+
+func TestReloadFailureKeepsSettings(t *testing.T) {
+	defer func() { configSettings(&Config{}).apply() }()
+	configSettings(&Config{}).apply()
+	timeout := *commandTimeout
+	// the duplicate cluster can not be registered
+	file := filepath.Join(t.TempDir(), "config.yml")
+	ioutil.WriteFile(file, []byte(`
+collectors: {cpusinfo: true}
+command_timeout: 5s
+clusters: [{name: a}, {name: a}]
+`), 0600)
+	old := *configFile
+	*configFile = file
+	defer func() { *configFile = old }()
+	if _, err := reloadExporter(); err == nil {
+		t.Fatalf("Expected the reload to fail")
+	}
+	if collectors["cpusinfo"].enabled || *commandTimeout != timeout {
+		t.Errorf("Expected the settings of the failed reload not to be applied")
+	}
+}
+
+func TestExporterStopWaitsForPolling(t *testing.T) {
+	started := make(chan struct{}, 1)
+	useRunner(t, funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: ctx.Err()}
+	}))
+	e, err := NewExporter(&Config{}, []string{"scheduler"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Start()
+	<-started
+	e.Stop()
+	// the poller has returned, no command of the old exporter is running
+	select {
+	case <-started:
+		t.Errorf("Expected no command after Stop")
+	default:
+	}
+}
@@ -0,0 +1,124 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+ * Label filters and cardinality limits of the configuration file are
+ * applied to the gathered Slurm metrics, so per-user or per-account
+ * series can be restricted without changing the collectors.
+ */
+
+// Series removed by the label filters and cardinality limits
+var droppedSeries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "slurm_exporter_dropped_series_total",
+		Help: "Number of series dropped by the label filters (reason filter) or cardinality limits (reason limit)",
+	},
+	[]string{"reason"})
+
+// FilterGatherer drops the series of another gatherer which do not pass
+// the label filters or exceed the cardinality limits
+type FilterGatherer struct {
+	source  prometheus.Gatherer
+	filters []LabelFilter
+	limits  map[string]int
+}
+
+func NewFilterGatherer(source prometheus.Gatherer, filters []LabelFilter, limits map[string]int) *FilterGatherer {
+	return &FilterGatherer{source: source, filters: filters, limits: limits}
+}
+
+func (g *FilterGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.source.Gather()
+	filtered := []*dto.MetricFamily{}
+	for _, mf := range families {
+		metrics := []*dto.Metric{}
+		for _, m := range mf.Metric {
+			if g.pass(m) {
+				metrics = append(metrics, m)
+			} else {
+				droppedSeries.WithLabelValues("filter").Inc()
+			}
+		}
+		mf.Metric = g.limit(metrics)
+		if len(mf.Metric) > 0 {
+			filtered = append(filtered, mf)
+		}
+	}
+	return filtered, err
+}
+
+// Returns true if the labels of the metric pass all filters
+func (g *FilterGatherer) pass(m *dto.Metric) bool {
+	for _, f := range g.filters {
+		for _, l := range m.Label {
+			if l.GetName() != f.Label {
+				continue
+			}
+			if f.allow != nil && !f.allow.MatchString(l.GetValue()) {
+				return false
+			}
+			if f.deny != nil && f.deny.MatchString(l.GetValue()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Keeps the series with the first values of each limited label in
+// alphabetical order, so the same series are kept on every scrape
+func (g *FilterGatherer) limit(metrics []*dto.Metric) []*dto.Metric {
+	for label, limit := range g.limits {
+		values := map[string]bool{}
+		for _, m := range metrics {
+			for _, l := range m.Label {
+				if l.GetName() == label {
+					values[l.GetValue()] = true
+				}
+			}
+		}
+		if len(values) <= limit {
+			continue
+		}
+		sorted := sortedKeys(values)
+		keep := map[string]bool{}
+		for _, v := range sorted[:limit] {
+			keep[v] = true
+		}
+		kept := []*dto.Metric{}
+		for _, m := range metrics {
+			drop := false
+			for _, l := range m.Label {
+				if l.GetName() == label && !keep[l.GetValue()] {
+					drop = true
+				}
+			}
+			if drop {
+				droppedSeries.WithLabelValues("limit").Inc()
+			} else {
+				kept = append(kept, m)
+			}
+		}
+		metrics = kept
+	}
+	return metrics
+}
@@ -0,0 +1,56 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFilterGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	jobs := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "jobs"}, []string{"user", "account"})
+	registry.MustRegister(jobs)
+	for _, l := range [][]string{
+		{"alice", "bio"}, {"bob", "bio"}, {"carol", "bio"}, {"dave", "test"}, {"root", "admin"},
+	} {
+		jobs.WithLabelValues(l...).Set(1)
+	}
+	cfg, err := ParseConfig([]byte(`
+label_filters:
+  - label: user
+    deny: root
+  - label: account
+    allow: bio|admin
+cardinality_limits:
+  user: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	families, err := NewFilterGatherer(registry, cfg.LabelFilters, cfg.CardinalityLimits).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []string{}
+	for _, m := range families[0].Metric {
+		users = append(users, m.Label[1].GetValue())
+	}
+	if len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Errorf("Expected the series of alice and bob, got %v", users)
+	}
+}
@@ -3,7 +3,10 @@ module github.com/vpenso/prometheus-slurm-exporter
 go 1.12
 
 require (
+	github.com/go-kit/kit v0.9.0
 	github.com/prometheus/client_golang v1.2.1
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
 	github.com/prometheus/common v0.7.0
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,299 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("gres", true, func() Collector { return NewGresCollector() })
+}
+
+/*
+ * Slurm prints the generic resources (GRES) of a node as a comma
+ * separated list of name[:type][:count] entries, each one optionally
+ * followed by the sockets or the indexes of the devices in parentheses:
+ *
+ *   gpu:a100:8(S:0-1),mps:200,shard:16
+ *   gpu:a100:2(IDX:0-1)
+ *
+ * The trackable resources (TRES) are printed as name=count, e.g.
+ * cpu=48,mem=105251M,gres/gpu=4.
+ */
+
+// Gres is one entry of a GRES list
+type Gres struct {
+	Name  string  // e.g. gpu, mps or shard
+	Type  string  // e.g. a100, empty without type
+	Count float64 // 1 without count
+}
+
+// ParseGres reads a GRES list, (null) and N/A have no entries
+func ParseGres(s string) ([]Gres, error) {
+	gres := []Gres{}
+	for _, entry := range splitGres(s) {
+		// drop the group after the count, e.g. (S:0-1), (IDX:0-1) or the
+		// usage of shards (2/4,0/4), but keep a (null) type field
+		if i := strings.LastIndex(entry, "("); i > 0 && strings.HasSuffix(entry, ")") && entry[i-1] != ':' {
+			entry = entry[:i]
+		}
+		fields := strings.Split(entry, ":")
+		g := Gres{Name: fields[0], Count: 1}
+		if g.Name == "" || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid GRES %q", entry)
+		}
+		switch len(fields) {
+		case 2:
+			// gpu:4 or gpu:a100
+			if count, err := parseCount(fields[1]); err == nil {
+				g.Count = count
+			} else {
+				g.Type = fields[1]
+			}
+		case 3:
+			g.Type = fields[1]
+			count, err := parseCount(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid count of GRES %q", entry)
+			}
+			g.Count = count
+		}
+		if g.Type == "(null)" {
+			g.Type = ""
+		}
+		gres = append(gres, g)
+	}
+	return gres, nil
+}
+
+// Split a GRES list on the commas outside of parentheses, e.g.
+// gpu:2(IDX:0,2),mps:100
+func splitGres(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "(null)" || s == "N/A" {
+		return nil
+	}
+	entries := []string{}
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				entries = append(entries, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(entries, s[start:])
+}
+
+// Multipliers of the count suffixes printed by Slurm
+var countUnits = map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40, 'P': 1 << 50}
+
+// Parse a count with an optional K, M, G, T or P suffix of a power of
+// 1024, as printed by Slurm for large counts and memory sizes
+func parseCount(s string) (float64, error) {
+	multiplier := 1.0
+	if n := len(s); n > 1 {
+		if m, ok := countUnits[s[n-1]]; ok {
+			multiplier = m
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid count %q", s)
+	}
+	return v * multiplier, nil
+}
+
+// ParseTRES reads a TRES list into a map from the resource name to its
+// count, memory is converted to bytes
+func ParseTRES(s string) (map[string]float64, error) {
+	tres := map[string]float64{}
+	s = strings.TrimSpace(s)
+	if s == "" || s == "(null)" || s == "N/A" {
+		return tres, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid TRES %q", entry)
+		}
+		count, err := parseCount(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid count of TRES %q", entry)
+		}
+		// memory without suffix is in MB
+		if last := kv[1][len(kv[1])-1]; kv[0] == "mem" && last >= '0' && last <= '9' {
+			count *= 1 << 20
+		}
+		tres[kv[0]] = count
+	}
+	return tres, nil
+}
+
+// Sum of the counts of the entries with the given name
+func gresCount(gres []Gres, name string) float64 {
+	count := 0.0
+	for _, g := range gres {
+		if g.Name == name {
+			count += g.Count
+		}
+	}
+	return count
+}
+
+// GresKey identifies a resource of a node, partition or feature
+type GresKey struct {
+	owner string // name of the node, partition or feature
+	gres  string
+	typ   string
+}
+
+type GresMetrics struct {
+	total float64
+	alloc float64
+}
+
+// ParseNodeGres reads the configured and allocated GRES of a node, false
+// if they can not be parsed
+func ParseNodeGres(node Node) (map[GresKey]*GresMetrics, bool) {
+	total, err := ParseGres(node.Gres)
+	if err != nil {
+		parseError("ParseNodeGres", "NodeName="+node.Name+" Gres="+node.Gres)
+		return nil, false
+	}
+	used, err := ParseGres(node.GresUsed)
+	if err != nil {
+		parseError("ParseNodeGres", "NodeName="+node.Name+" GresUsed="+node.GresUsed)
+		return nil, false
+	}
+	gres := map[GresKey]*GresMetrics{}
+	for _, g := range total {
+		key := GresKey{node.Name, g.Name, g.Type}
+		if gres[key] == nil {
+			gres[key] = &GresMetrics{}
+		}
+		gres[key].total += g.Count
+	}
+	for _, g := range used {
+		key := GresKey{node.Name, g.Name, g.Type}
+		if gres[key] == nil {
+			gres[key] = &GresMetrics{}
+		}
+		gres[key].alloc += g.Count
+	}
+	return gres, true
+}
+
+// ParseGresMetrics sums up the GRES of the nodes by node, by partition
+// and by feature
+func ParseGresMetrics(nodes []Node) (byNode, byPartition, byFeature map[GresKey]*GresMetrics) {
+	byNode = map[GresKey]*GresMetrics{}
+	byPartition = map[GresKey]*GresMetrics{}
+	byFeature = map[GresKey]*GresMetrics{}
+	add := func(data map[GresKey]*GresMetrics, key GresKey, m *GresMetrics) {
+		if data[key] == nil {
+			data[key] = &GresMetrics{}
+		}
+		data[key].total += m.total
+		data[key].alloc += m.alloc
+	}
+	for _, node := range nodes {
+		gres, ok := ParseNodeGres(node)
+		if !ok {
+			continue
+		}
+		for key, m := range gres {
+			byNode[key] = m
+			for _, partition := range node.Partitions {
+				add(byPartition, GresKey{partition, key.gres, key.typ}, m)
+			}
+			add(byFeature, GresKey{node.Features, key.gres, key.typ}, m)
+		}
+	}
+	return byNode, byPartition, byFeature
+}
+
+/*
+ * Implement the Prometheus Collector interface and feed the
+ * Slurm GRES metrics into it.
+ * https://godoc.org/github.com/prometheus/client_golang/prometheus#Collector
+ */
+
+func NewGresCollector() *GresCollector {
+	return &GresCollector{
+		nodeTotal: prometheus.NewDesc("slurm_node_gres_total", "Configured generic resources of a node",
+			[]string{"node", "gres", "type"}, nil),
+		nodeAlloc: prometheus.NewDesc("slurm_node_gres_alloc", "Allocated generic resources of a node",
+			[]string{"node", "gres", "type"}, nil),
+		partitionTotal: prometheus.NewDesc("slurm_partition_gres_total", "Configured generic resources of the nodes of a partition",
+			[]string{"partition", "gres", "type"}, nil),
+		partitionAlloc: prometheus.NewDesc("slurm_partition_gres_alloc", "Allocated generic resources of the nodes of a partition",
+			[]string{"partition", "gres", "type"}, nil),
+		featureTotal: prometheus.NewDesc("slurm_feature_gres_total", "Configured generic resources of the nodes with the same features",
+			[]string{"feature", "gres", "type"}, nil),
+		featureAlloc: prometheus.NewDesc("slurm_feature_gres_alloc", "Allocated generic resources of the nodes with the same features",
+			[]string{"feature", "gres", "type"}, nil),
+	}
+}
+
+type GresCollector struct {
+	nodeTotal      *prometheus.Desc
+	nodeAlloc      *prometheus.Desc
+	partitionTotal *prometheus.Desc
+	partitionAlloc *prometheus.Desc
+	featureTotal   *prometheus.Desc
+	featureAlloc   *prometheus.Desc
+}
+
+// Send all metric descriptions
+func (gc *GresCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gc.nodeTotal
+	ch <- gc.nodeAlloc
+	ch <- gc.partitionTotal
+	ch <- gc.partitionAlloc
+	ch <- gc.featureTotal
+	ch <- gc.featureAlloc
+}
+
+func (gc *GresCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	nodes, err := scrape.Nodes()
+	if err != nil {
+		return err
+	}
+	byNode, byPartition, byFeature := ParseGresMetrics(nodes)
+	send := func(data map[GresKey]*GresMetrics, total, alloc *prometheus.Desc) {
+		for key, m := range data {
+			ch <- prometheus.MustNewConstMetric(total, prometheus.GaugeValue, m.total, key.owner, key.gres, key.typ)
+			ch <- prometheus.MustNewConstMetric(alloc, prometheus.GaugeValue, m.alloc, key.owner, key.gres, key.typ)
+		}
+	}
+	send(byNode, gc.nodeTotal, gc.nodeAlloc)
+	send(byPartition, gc.partitionTotal, gc.partitionAlloc)
+	send(byFeature, gc.featureTotal, gc.featureAlloc)
+	return nil
+}
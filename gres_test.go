@@ -0,0 +1,105 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGres(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  []Gres
+	}{
+		{"(null)", []Gres{}},
+		{"", []Gres{}},
+		{"gpu:a100:8(S:0-1)", []Gres{{"gpu", "a100", 8}}},
+		{"gpu:16", []Gres{{"gpu", "", 16}}},
+		{"gpu:4", []Gres{{"gpu", "", 4}}},
+		{"gpu", []Gres{{"gpu", "", 1}}},
+		{"gpu:v100:2,mps:200", []Gres{{"gpu", "v100", 2}, {"mps", "", 200}}},
+		{"gpu:a100:10(IDX:0,2-9),shard:a100:40", []Gres{{"gpu", "a100", 10}, {"shard", "a100", 40}}},
+		{"gpu:(null):0(IDX:N/A)", []Gres{{"gpu", "", 0}}},
+		{"mps:1K", []Gres{{"mps", "", 1024}}},
+		{"shard:2(2/4,0/4)", []Gres{{"shard", "", 2}}},
+		{"shard:a100:8(8/8,0/8)", []Gres{{"shard", "a100", 8}}},
+		{"gpu:a100:2(IDX:0-1),shard:a100:2(2/4,0/4)", []Gres{{"gpu", "a100", 2}, {"shard", "a100", 2}}},
+		{"gpu:(null):2(IDX:0-1)", []Gres{{"gpu", "", 2}}},
+		{"gpu:(null)", []Gres{{"gpu", "", 1}}},
+	} {
+		got, err := ParseGres(tc.input)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.input, tc.want, got)
+		}
+	}
+	for _, input := range []string{"gpu:a100:x", ":4", "gpu:a:b:c"} {
+		if _, err := ParseGres(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestParseTRES(t *testing.T) {
+	tres, err := ParseTRES("cpu=48,mem=105251M,billing=48,gres/gpu=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"cpu": 48, "mem": 105251 << 20, "billing": 48, "gres/gpu": 4}
+	if !reflect.DeepEqual(tres, want) {
+		t.Errorf("Expected %v, got %v", want, tres)
+	}
+	if tres, _ := ParseTRES("mem=2G"); tres["mem"] != 2<<30 {
+		t.Errorf("Expected 2G of memory, got %v", tres["mem"])
+	}
+	if tres, _ := ParseTRES("mem=512"); tres["mem"] != 512<<20 {
+		t.Errorf("Expected 512M of memory, got %v", tres["mem"])
+	}
+	if _, err := ParseTRES("cpu"); err == nil {
+		t.Errorf("Expected an error without count")
+	}
+}
+
+func TestParseGresMetrics(t *testing.T) {
+	byNode, byPartition, byFeature := ParseGresMetrics(readNodes(t))
+	if m := byNode[GresKey{"milton-gpu-003", "gpu", "V100"}]; m == nil || m.total != 4 || m.alloc != 2 {
+		t.Errorf("Unexpected GPUs of milton-gpu-003: %+v", m)
+	}
+	if len(byNode) != 5 {
+		t.Errorf("Expected GRES on 5 nodes, got %d", len(byNode))
+	}
+	if m := byPartition[GresKey{"gpuq", "gpu", "V100"}]; m == nil || m.total != 12 || m.alloc != 3 {
+		t.Errorf("Unexpected V100 GPUs of the gpuq partition: %+v", m)
+	}
+	if m := byFeature[GresKey{"", "gpu", "P100"}]; m == nil || m.total != 4 || m.alloc != 1 {
+		t.Errorf("Unexpected P100 GPUs without feature: %+v", m)
+	}
+}
+
+func TestParseNodesGPUMetricsCounts(t *testing.T) {
+	nodes := []Node{
+		{Name: "a", State: "mixed", Features: "a100", Gres: "gpu:a100:16(S:0-1),mps:400", GresUsed: "gpu:a100:10(IDX:0-9),mps:0"},
+		{Name: "b", State: "idle", Features: "a100", Gres: "gpu:a100:8", GresUsed: "gpu:a100:0(IDX:N/A)"},
+	}
+	metrics := ParseNodesGPUMetrics(nodes)
+	if metrics[MetricKey{"alloc", "a100"}] != 10 || metrics[MetricKey{"free", "a100"}] != 14 {
+		t.Errorf("Expected 10 allocated and 14 free GPUs, got %v", metrics)
+	}
+}
@@ -0,0 +1,180 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ * Every Slurm command is an RPC to slurmctld. While slurmctld is
+ * overloaded or failing over, a GuardedRunner keeps the exporter from
+ * adding to its load: it bounds the number of commands in flight, runs the
+ * same command at most once per minimum interval, and after a number of
+ * timeouts in a row of one command stops running the commands of its
+ * cluster for a back-off period. Meanwhile the last successful output of
+ * each command is served and slurm_exporter_circuit_open reports the
+ * stale data.
+ */
+
+// Returned while the circuit of a cluster is open and no previous output
+// of the command is available
+var errCircuitOpen = errors.New("circuit breaker open after repeated timeouts")
+
+// Whether the commands of a cluster are suspended
+var circuitOpen = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "slurm_exporter_circuit_open",
+		Help: "Whether the Slurm commands of a cluster are suspended after repeated timeouts and stale data is served",
+	},
+	[]string{"cluster"})
+
+// GuardedRunner limits the commands run by another runner
+type GuardedRunner struct {
+	runner      CommandRunner
+	slots       chan struct{} // nil without concurrency limit
+	MinInterval time.Duration // between two runs of the same command
+	Failures    int           // timeouts in a row opening the circuit, zero disables it
+	Backoff     time.Duration // time the circuit stays open
+
+	mu       sync.Mutex
+	last     map[string]*invocation // last run of each command
+	stale    map[string][]byte      // last successful output of each command
+	breakers map[string]*breaker    // by cluster name
+}
+
+// A run of a command, done is closed once it finished
+type invocation struct {
+	started time.Time
+	done    chan struct{}
+	out     []byte
+	err     error
+}
+
+// Circuit breaker of a cluster
+type breaker struct {
+	timeouts  map[string]int // timeouts in a row by command
+	open      bool
+	openUntil time.Time
+}
+
+// NewGuardedRunner returns a runner running at most concurrency commands
+// of runner at the same time, zero disables the limit
+func NewGuardedRunner(runner CommandRunner, concurrency int) *GuardedRunner {
+	r := &GuardedRunner{
+		runner:   runner,
+		last:     map[string]*invocation{},
+		stale:    map[string][]byte{},
+		breakers: map[string]*breaker{},
+	}
+	if concurrency > 0 {
+		r.slots = make(chan struct{}, concurrency)
+	}
+	return r
+}
+
+func (r *GuardedRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cluster := clusterFromContext(ctx).label()
+	key := strings.Join(append([]string{cluster, name}, args...), " ")
+
+	r.mu.Lock()
+	// a command run recently, or still running, is not run again
+	if last := r.last[key]; last != nil && time.Since(last.started) < r.MinInterval {
+		r.mu.Unlock()
+		select {
+		case <-last.done:
+			return last.out, last.err
+		case <-ctx.Done():
+			return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: ctx.Err()}
+		}
+	}
+	if b := r.breakers[cluster]; b != nil && b.open {
+		if time.Now().Before(b.openUntil) {
+			out, ok := r.stale[key]
+			r.mu.Unlock()
+			if !ok {
+				return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: errCircuitOpen}
+			}
+			return out, nil
+		}
+		// the back-off is over, the commands run again whatever their
+		// result is and another timeout opens the circuit again
+		b.open = false
+		circuitOpen.WithLabelValues(cluster).Set(0)
+		level.Info(logger).Log("msg", "Slurm commands resumed", "cluster", cluster)
+	}
+	run := &invocation{started: time.Now(), done: make(chan struct{})}
+	r.last[key] = run
+	r.mu.Unlock()
+
+	var started bool
+	run.out, started, run.err = r.run(ctx, name, args)
+	r.record(key, cluster, run, started)
+	close(run.done)
+	return run.out, run.err
+}
+
+// Run a command once a slot is free, started is false if the context
+// ended while waiting for a slot
+func (r *GuardedRunner) run(ctx context.Context, name string, args []string) (out []byte, started bool, err error) {
+	if r.slots != nil {
+		select {
+		case r.slots <- struct{}{}:
+			defer func() { <-r.slots }()
+		case <-ctx.Done():
+			return nil, false, &CommandError{Command: name, Args: args, ExitCode: -1, Err: ctx.Err()}
+		}
+	}
+	out, err = r.runner.Run(ctx, name, args...)
+	return out, true, err
+}
+
+// Keep the output of a successful run and open the circuit of the
+// cluster after too many timeouts in a row of the command, a success of
+// the command resets its timeouts. A command which did not get a slot in
+// time never reached slurmctld and is not counted.
+func (r *GuardedRunner) record(key string, cluster string, run *invocation, started bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.breakers[cluster]
+	if b == nil {
+		b = &breaker{timeouts: map[string]int{}}
+		r.breakers[cluster] = b
+	}
+	if run.err == nil {
+		r.stale[key] = run.out
+		delete(b.timeouts, key)
+		circuitOpen.WithLabelValues(cluster).Set(0)
+		return
+	}
+	if cerr, ok := run.err.(*CommandError); !ok || cerr.Err != context.DeadlineExceeded || !started {
+		return
+	}
+	b.timeouts[key]++
+	if r.Failures > 0 && b.timeouts[key] >= r.Failures {
+		b.open = true
+		b.openUntil = time.Now().Add(r.Backoff)
+		circuitOpen.WithLabelValues(cluster).Set(1)
+		level.Warn(logger).Log("msg", "Slurm commands suspended after repeated timeouts", "cluster", cluster, "command", key, "timeouts", b.timeouts[key], "backoff", r.Backoff)
+	}
+}
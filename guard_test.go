@@ -0,0 +1,204 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Runs commands through a function
+type funcRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func (f funcRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return f(ctx, name, args...)
+}
+
+func TestGuardedRunnerConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, max := 0, 0
+	r := NewGuardedRunner(funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil, nil
+	}), 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			r.Run(context.Background(), "squeue")
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	if max != 2 {
+		t.Errorf("Expected at most 2 commands at the same time, got %d", max)
+	}
+}
+
+func TestGuardedRunnerMinInterval(t *testing.T) {
+	counter := &countingRunner{runner: fakeRunner{"sdiag": "test_data/sdiag.txt"}, count: map[string]int{}}
+	r := NewGuardedRunner(counter, 0)
+	r.MinInterval = time.Hour
+	first, _ := r.Run(context.Background(), "sdiag")
+	second, _ := r.Run(context.Background(), "sdiag")
+	if counter.count["sdiag"] != 1 || string(first) != string(second) {
+		t.Errorf("Expected sdiag to run once, ran %d times", counter.count["sdiag"])
+	}
+	// another cluster is another command
+	r.Run(withCluster(context.Background(), &Cluster{Name: "a"}), "sdiag")
+	if counter.count["sdiag"] != 2 {
+		t.Errorf("Expected sdiag to run for the cluster, ran %d times", counter.count["sdiag"])
+	}
+}
+
+func TestGuardedRunnerCircuitBreaker(t *testing.T) {
+	timeout := false
+	calls := 0
+	r := NewGuardedRunner(funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls++
+		if timeout {
+			return nil, &CommandError{Command: name, ExitCode: -1, Err: context.DeadlineExceeded}
+		}
+		return []byte("output of " + name), nil
+	}), 0)
+	r.Failures = 2
+	r.Backoff = 50 * time.Millisecond
+	ctx := withCluster(context.Background(), &Cluster{Name: "breaker"})
+	gauge := circuitOpen.WithLabelValues("breaker")
+
+	r.Run(ctx, "squeue")
+	timeout = true
+	r.Run(ctx, "squeue")
+	if testutil.ToFloat64(gauge) != 0 {
+		t.Errorf("Expected the circuit to stay closed after one timeout")
+	}
+	r.Run(ctx, "squeue")
+	if testutil.ToFloat64(gauge) != 1 {
+		t.Errorf("Expected the circuit to open after two timeouts")
+	}
+
+	// the last output is served while the circuit is open
+	out, err := r.Run(ctx, "squeue")
+	if err != nil || string(out) != "output of squeue" || calls != 3 {
+		t.Errorf("Expected the stale output without running squeue, got %q, %v after %d calls", out, err, calls)
+	}
+	if _, err := r.Run(ctx, "sdiag"); err == nil || err.(*CommandError).Err != errCircuitOpen {
+		t.Errorf("Expected an open circuit error without previous output, got %v", err)
+	}
+	if _, err := r.Run(context.Background(), "sdiag"); err == nil || calls != 4 {
+		t.Errorf("Expected the local cluster to be queried, got %v after %d calls", err, calls)
+	}
+
+	// the circuit closes once the back-off is over
+	time.Sleep(60 * time.Millisecond)
+	timeout = false
+	if out, err := r.Run(ctx, "squeue"); err != nil || string(out) != "output of squeue" {
+		t.Errorf("Expected squeue to run after the back-off, got %q, %v", out, err)
+	}
+	if testutil.ToFloat64(gauge) != 0 {
+		t.Errorf("Expected the circuit to close after a success")
+	}
+}
+
+func TestGuardedRunnerTimeoutsByCommand(t *testing.T) {
+	r := NewGuardedRunner(funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if name == "squeue" {
+			return nil, &CommandError{Command: name, ExitCode: -1, Err: context.DeadlineExceeded}
+		}
+		return []byte("output of " + name), nil
+	}), 0)
+	r.Failures = 2
+	r.Backoff = time.Minute
+	ctx := withCluster(context.Background(), &Cluster{Name: "slow-squeue"})
+	gauge := circuitOpen.WithLabelValues("slow-squeue")
+	// a fast sdiag in between does not reset the timeouts of squeue
+	r.Run(ctx, "squeue")
+	r.Run(ctx, "sdiag")
+	r.Run(ctx, "squeue")
+	if testutil.ToFloat64(gauge) != 1 {
+		t.Errorf("Expected the circuit to open after two squeue timeouts")
+	}
+}
+
+func TestGuardedRunnerSlotWait(t *testing.T) {
+	block := make(chan struct{})
+	r := NewGuardedRunner(funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		<-block
+		return []byte("output of " + name), nil
+	}), 1)
+	r.Failures = 1
+	cluster := &Cluster{Name: "busy"}
+	go r.Run(withCluster(context.Background(), cluster), "squeue")
+	time.Sleep(10 * time.Millisecond)
+	// sdiag can not get a slot before its deadline, slurmctld is not slow
+	ctx, cancel := context.WithTimeout(withCluster(context.Background(), cluster), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Run(ctx, "sdiag"); err == nil {
+		t.Errorf("Expected sdiag to time out waiting for a slot")
+	}
+	if testutil.ToFloat64(circuitOpen.WithLabelValues("busy")) != 0 {
+		t.Errorf("Expected the wait for a slot not to open the circuit")
+	}
+	close(block)
+}
+
+func TestGuardedRunnerFailureAfterBackoff(t *testing.T) {
+	var err error
+	r := NewGuardedRunner(funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return nil, err
+	}), 0)
+	r.Failures = 1
+	r.Backoff = 20 * time.Millisecond
+	ctx := withCluster(context.Background(), &Cluster{Name: "refused"})
+	gauge := circuitOpen.WithLabelValues("refused")
+	err = &CommandError{Command: "squeue", ExitCode: -1, Err: context.DeadlineExceeded}
+	r.Run(ctx, "squeue")
+	if testutil.ToFloat64(gauge) != 1 {
+		t.Fatalf("Expected the circuit to open after a timeout")
+	}
+	// after the back-off squeue runs and fails without timeout, no stale
+	// data is served
+	time.Sleep(30 * time.Millisecond)
+	err = &CommandError{Command: "squeue", ExitCode: 1, Err: errors.New("connection refused")}
+	if _, runErr := r.Run(ctx, "squeue"); runErr != err {
+		t.Errorf("Expected the error of squeue, got %v", runErr)
+	}
+	if testutil.ToFloat64(gauge) != 0 {
+		t.Errorf("Expected the circuit to be closed after the back-off")
+	}
+}
+
+func TestNewRunnerResetsCircuits(t *testing.T) {
+	circuitOpen.WithLabelValues("reloaded").Set(1)
+	newRunner(&Config{})
+	if testutil.ToFloat64(circuitOpen.WithLabelValues("reloaded")) != 0 {
+		t.Errorf("Expected the circuits to be reset by a new runner")
+	}
+}
@@ -0,0 +1,140 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+/*
+ * /-/healthy reports that the exporter is running. /-/ready reports
+ * whether the Slurm data can be read: the exporter is not ready until
+ * the first Slurm command succeeded, and again while all commands of a
+ * cluster failed a number of times in a row. The results are tracked by
+ * cluster and command, so a single command which always fails, e.g. a
+ * restricted sdiag, or a cluster which is down are not hidden by the
+ * commands succeeding elsewhere. Probes do not change the readiness.
+ */
+
+// Health tracks the results of the Slurm commands
+type Health struct {
+	threshold int
+
+	mu        sync.Mutex
+	succeeded bool
+	lastErr   error
+	clusters  map[string]*clusterHealth // by cluster name, empty for the local cluster
+}
+
+// Results of the commands of a cluster
+type clusterHealth struct {
+	failures map[string]int // failures in a row by command
+	lastErr  error
+}
+
+// NewHealth returns a tracker which is not ready while every command of
+// a cluster failed threshold times in a row
+func NewHealth(threshold int) *Health {
+	return &Health{threshold: threshold, clusters: map[string]*clusterHealth{}}
+}
+
+// Record the result of a command executed for a cluster
+func (h *Health) Record(cluster string, command string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := h.clusters[cluster]
+	if c == nil {
+		c = &clusterHealth{failures: map[string]int{}}
+		h.clusters[cluster] = c
+	}
+	if err != nil {
+		c.failures[command]++
+		c.lastErr = err
+		h.lastErr = err
+		return
+	}
+	c.failures[command] = 0
+	h.succeeded = true
+}
+
+// Ready returns nil if the exporter is ready, otherwise the reason
+func (h *Health) Ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case !h.succeeded && h.lastErr != nil:
+		return fmt.Errorf("no successful Slurm command yet, last error: %v", h.lastErr)
+	case !h.succeeded:
+		return fmt.Errorf("no successful Slurm command yet")
+	}
+	names := []string{}
+	for name := range h.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c := h.clusters[name]; c.failed(h.threshold) {
+			return fmt.Errorf("all Slurm commands of cluster %q failed %d times in a row, last error: %v", name, h.threshold, c.lastErr)
+		}
+	}
+	return nil
+}
+
+// Returns true if every command failed at least threshold times in a row
+func (c *clusterHealth) failed(threshold int) bool {
+	for _, failures := range c.failures {
+		if failures < threshold {
+			return false
+		}
+	}
+	return len(c.failures) > 0
+}
+
+type noHealthKey struct{}
+
+// Returns a context whose command results do not change the readiness,
+// used by probes and the checks at startup
+func withoutHealth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noHealthKey{}, true)
+}
+
+// Record the result of a command executed with the context, commands
+// cancelled on reload or shutdown are not recorded
+func recordHealth(ctx context.Context, command string, err error) {
+	if ctx.Value(noHealthKey{}) != nil || ctx.Err() == context.Canceled {
+		return
+	}
+	health.Record(clusterFromContext(ctx).label(), command, err)
+}
+
+// Health of the Slurm commands, the threshold is set in main()
+var health = NewHealth(3)
+
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Healthy\n"))
+}
+
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := health.Ready(); err != nil {
+		http.Error(w, "Not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("Ready\n"))
+}
@@ -0,0 +1,119 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	h := NewHealth(2)
+	if h.Ready() == nil {
+		t.Errorf("Expected not ready before the first command")
+	}
+	h.Record("", "sinfo", errors.New("sinfo failed"))
+	if err := h.Ready(); err == nil || !strings.Contains(err.Error(), "sinfo failed") {
+		t.Errorf("Expected not ready with the last error, got %v", err)
+	}
+	h.Record("", "sinfo", nil)
+	if err := h.Ready(); err != nil {
+		t.Errorf("Expected ready after a successful command, got %v", err)
+	}
+	h.Record("", "sinfo", errors.New("sinfo failed"))
+	if err := h.Ready(); err != nil {
+		t.Errorf("Expected ready after a single failure, got %v", err)
+	}
+	h.Record("", "sinfo", errors.New("sinfo failed"))
+	if err := h.Ready(); err == nil {
+		t.Errorf("Expected not ready after two failures in a row")
+	}
+}
+
+func TestHealthByCommand(t *testing.T) {
+	h := NewHealth(2)
+	// sdiag is restricted and always fails, squeue keeps the exporter ready
+	for i := 0; i < 3; i++ {
+		h.Record("", "sdiag", errors.New("access denied"))
+		h.Record("", "squeue", nil)
+	}
+	if err := h.Ready(); err != nil {
+		t.Errorf("Expected ready while squeue succeeds, got %v", err)
+	}
+	// a healthy cluster does not hide a cluster which is down
+	for i := 0; i < 2; i++ {
+		h.Record("vc7", "squeue", errors.New("connection refused"))
+		h.Record("vc7", "sdiag", errors.New("connection refused"))
+		h.Record("milton", "squeue", nil)
+	}
+	if err := h.Ready(); err == nil || !strings.Contains(err.Error(), "vc7") {
+		t.Errorf("Expected not ready while vc7 is down, got %v", err)
+	}
+}
+
+func TestProbeDoesNotChangeHealth(t *testing.T) {
+	old := health
+	defer func() { health = old }()
+	health = NewHealth(1)
+	useRunner(t, fakeRunner{"sdiag": "test_data/sdiag.txt"})
+	RunCommand(withoutHealth(context.Background()), "sdiag")
+	if health.Ready() == nil {
+		t.Errorf("Expected a command without health not to be recorded")
+	}
+	RunCommand(context.Background(), "sdiag")
+	if err := health.Ready(); err != nil {
+		t.Errorf("Expected ready after a successful command, got %v", err)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	old := health
+	defer func() { health = old }()
+	health = NewHealth(1)
+	w := httptest.NewRecorder()
+	readyHandler(w, httptest.NewRequest("GET", "/-/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	health.Record("", "sdiag", nil)
+	w = httptest.NewRecorder()
+	readyHandler(w, httptest.NewRequest("GET", "/-/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	e, err := NewExporter(&Config{Clusters: []Cluster{{Name: "milton"}}}, enabledCollectors(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	e.ServeLanding(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "<li>queue</li>") || !strings.Contains(body, "cluster=milton") {
+		t.Errorf("Unexpected landing page %d: %s", w.Code, body)
+	}
+	w = httptest.NewRecorder()
+	e.ServeLanding(w, httptest.NewRequest("GET", "/metrix", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown paths, got %d", w.Code)
+	}
+}
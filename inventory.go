@@ -0,0 +1,223 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ * All node based collectors (nodes, nodesinfo, cpus, cpusinfo,
+ * partitions) read the same node inventory, scontrol is executed only
+ * once per scrape.
+ */
+
+// Node as listed by scontrol
+type Node struct {
+	Name           string
+	Partitions     []string
+	State          string   // base state in lower case, e.g. mixed
+	Flags          []string // state flags in lower case, e.g. drain
+	CPUAlloc       float64
+	CPUTotal       float64
+	CPUEffective   float64 // CPUs usable by jobs, zero if not reported
+	CPULoad        float64
+	HasCPULoad     bool    // false if the load of the node is unknown, e.g. while it is down
+	RealMemory     float64 // configured memory in MB
+	AllocMemory    float64 // memory allocated to jobs in MB
+	FreeMemory     float64 // free memory reported by the OS in MB
+	HasFreeMemory  bool    // false if the free memory is unknown
+	Gres           string
+	GresUsed       string
+	CfgTRES        string // configured TRES, e.g. cpu=48,mem=105251M
+	AllocTRES      string // TRES allocated to jobs
+	Features       string // available features, comma separated
+	ActiveFeatures string
+	Weight         string
+	Arch           string
+	OS             string
+	Reason         string    // why the node is down or drained
+	ReasonUser     string    // who set the reason
+	ReasonTime     time.Time // when the reason was set, zero if unknown
+}
+
+// Execute the scontrol command and return its output, one node per line
+func NodesInventoryData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "scontrol", "-o", "-d", "show", "node")
+}
+
+// Start of each Key=Value pair of a scontrol line
+var scontrolKey = regexp.MustCompile(`(?:^|\s)([A-Za-z_/]+)=`)
+
+// Fields printed last by scontrol in this order, their values may contain
+// any text. Comment and Extra follow the Reason since Slurm 21.08.
+var scontrolTextKeys = []string{"Reason", "Comment", "Extra"}
+
+// Split a line of scontrol -o output into its fields. Values may contain
+// spaces (OS, Reason), the free text fields are cut from the end first.
+func parseScontrolLine(line string) map[string]string {
+	fields := map[string]string{}
+	for i := len(scontrolTextKeys) - 1; i >= 0; i-- {
+		key := " " + scontrolTextKeys[i] + "="
+		if j := strings.Index(line, key); j >= 0 {
+			fields[scontrolTextKeys[i]] = strings.TrimSpace(line[j+len(key):])
+			line = line[:j]
+		}
+	}
+	keys := scontrolKey.FindAllStringSubmatchIndex(line, -1)
+	for i, k := range keys {
+		end := len(line)
+		if i+1 < len(keys) {
+			end = keys[i+1][0]
+		}
+		fields[line[k[2]:k[3]]] = strings.TrimSpace(line[k[1]:end])
+	}
+	return fields
+}
+
+// ParseNodes reads the nodes from the scontrol output
+func ParseNodes(input []byte) []Node {
+	nodes := []Node{}
+	for _, line := range strings.Split(string(input), "\n") {
+		if !strings.HasPrefix(line, "NodeName=") {
+			continue
+		}
+		// free text like the reason becomes label values, which have to
+		// be valid UTF-8
+		f := parseScontrolLine(strings.ToValidUTF8(line, "\uFFFD"))
+		state, flags := parseNodeState(f["State"])
+		node := Node{
+			Name:           f["NodeName"],
+			State:          state,
+			Flags:          flags,
+			CPUAlloc:       parseFloat("ParseNodes", f["CPUAlloc"]),
+			CPUTotal:       parseFloat("ParseNodes", f["CPUTot"]),
+			CPULoad:        parseFloat("ParseNodes", f["CPULoad"]),
+			HasCPULoad:     known(f["CPULoad"]),
+			RealMemory:     parseFloat("ParseNodes", f["RealMemory"]),
+			AllocMemory:    parseFloat("ParseNodes", f["AllocMem"]),
+			FreeMemory:     parseFloat("ParseNodes", f["FreeMem"]),
+			HasFreeMemory:  known(f["FreeMem"]),
+			Gres:           f["Gres"],
+			GresUsed:       f["GresUsed"],
+			CfgTRES:        f["CfgTRES"],
+			AllocTRES:      f["AllocTRES"],
+			Features:       nodeAttribute(f["AvailableFeatures"]),
+			ActiveFeatures: nodeAttribute(f["ActiveFeatures"]),
+			Weight:         f["Weight"],
+			Arch:           nodeAttribute(f["Arch"]),
+			OS:             nodeAttribute(f["OS"]),
+		}
+		node.Reason, node.ReasonUser, node.ReasonTime = parseReason(f["Reason"])
+		// printed since Slurm 22.05
+		if v, ok := f["CPUEfctv"]; ok {
+			node.CPUEffective = parseFloat("ParseNodes", v)
+		}
+		if f["Partitions"] != "" {
+			node.Partitions = strings.Split(f["Partitions"], ",")
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Returns true if scontrol printed a value, N/A for a node which does not
+// report it, e.g. the free memory of a node which is down
+func known(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && s != "N/A"
+}
+
+// Value of an attribute of a node, empty if Slurm prints (null) or N/A
+// for an unset attribute
+func nodeAttribute(s string) string {
+	if s == "(null)" || s == "N/A" {
+		return ""
+	}
+	return s
+}
+
+// Suffix of the reason of a node with the user and time it was set, e.g.
+// Kill task failed [root@2020-11-02T09:14:27]
+var reasonSuffix = regexp.MustCompile(`\s*\[([^\]@]*)@(\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d)\]$`)
+
+// Split the reason of a node into its text, user and time, the time is
+// printed in the local time zone of slurmctld
+func parseReason(s string) (string, string, time.Time) {
+	m := reasonSuffix.FindStringSubmatchIndex(s)
+	if m == nil {
+		return s, "", time.Time{}
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", s[m[4]:m[5]], time.Local)
+	if err != nil {
+		parseError("ParseNodes", "Reason="+s)
+	}
+	return s[:m[0]], s[m[2]:m[3]], t
+}
+
+// HasFlag returns true if the node state carries the flag
+func (n *Node) HasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// StateLong returns the state as printed by sinfo %T, e.g. drained for
+// an idle node with the drain flag, or mixed* for a node not responding
+func (n *Node) StateLong() string {
+	busy := n.State == "allocated" || n.State == "mixed" || n.HasFlag("completing")
+	state := n.State
+	switch {
+	case n.HasFlag("maintenance") || n.HasFlag("maint"):
+		state = "maint"
+	case n.HasFlag("drain") && busy:
+		state = "draining"
+	case n.HasFlag("drain"):
+		state = "drained"
+	case n.HasFlag("fail") && busy:
+		state = "failing"
+	case n.HasFlag("fail"):
+		state = "fail"
+	case n.State == "down":
+	case n.HasFlag("completing"):
+		state = "completing"
+	case n.State == "idle" && n.HasFlag("reserved"):
+		state = "reserved"
+	}
+	if n.HasFlag("not_responding") {
+		state += "*"
+	}
+	return state
+}
+
+// Split the CPUs of a node like sinfo %C: the CPUs of down nodes and the
+// unallocated CPUs of drained or failing nodes are neither allocated nor
+// idle but other
+func (n *Node) CPUs() (alloc, idle, other float64) {
+	switch {
+	case n.State == "down":
+		return 0, 0, n.CPUTotal
+	case n.HasFlag("drain") || n.HasFlag("fail"):
+		return n.CPUAlloc, 0, n.CPUTotal - n.CPUAlloc
+	}
+	return n.CPUAlloc, n.CPUTotal - n.CPUAlloc, 0
+}
@@ -0,0 +1,96 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Nodes of the scontrol test data
+func readNodes(t *testing.T) []Node {
+	data, err := ioutil.ReadFile("test_data/scontrol.txt")
+	if err != nil {
+		t.Fatalf("Can not open test data: %v", err)
+	}
+	return ParseNodes(data)
+}
+
+func TestParseNodes(t *testing.T) {
+	nodes := readNodes(t)
+	if len(nodes) != 39 {
+		t.Fatalf("Expected 39 nodes, got %d", len(nodes))
+	}
+	node := nodes[1]
+	if node.Name != "milton-gpu-002" || node.State != "idle" || !node.HasFlag("drain") ||
+		!node.HasFlag("not_responding") || node.CPUTotal != 48 || node.RealMemory != 105251 ||
+		node.FreeMemory != 108394 || node.Gres != "gpu:V100:4(S:0-1)" ||
+		node.Reason != "Kill task failed" || node.ReasonUser != "root" ||
+		node.ReasonTime.Format("2006-01-02T15:04:05") != "2020-11-02T09:14:27" ||
+		len(node.Partitions) != 1 || node.Partitions[0] != "gpuq" {
+		t.Errorf("Unexpected node %+v", node)
+	}
+	if s := node.StateLong(); s != "drained*" {
+		t.Errorf("Expected state drained*, got %s", s)
+	}
+	// the comment printed after the reason since Slurm 21.08
+	for _, node := range nodes {
+		if node.Name == "milton-sml-010" && (node.Reason != "memory replacement" || node.ReasonUser != "iskander.j" ||
+			node.ReasonTime.Format("2006-01-02T15:04:05") != "2020-11-03T12:00:01") {
+			t.Errorf("Unexpected reason of %s: %q by %q at %v", node.Name, node.Reason, node.ReasonUser, node.ReasonTime)
+		}
+	}
+}
+
+func TestParseNodesEffectiveCPUs(t *testing.T) {
+	nodes := ParseNodes([]byte("NodeName=n1 CPUAlloc=8 CPUEfctv=60 CPUTot=64 CPULoad=1.00 RealMemory=1000 AllocMem=0 FreeMem=900 " +
+		"State=MIXED CfgTRES=cpu=64,mem=1000M AllocTRES=cpu=8\n"))
+	if len(nodes) != 1 || nodes[0].CPUEffective != 60 || nodes[0].CfgTRES != "cpu=64,mem=1000M" || nodes[0].AllocTRES != "cpu=8" {
+		t.Errorf("Unexpected nodes %+v", nodes)
+	}
+}
+
+func TestParseReason(t *testing.T) {
+	reason, user, since := parseReason("memory replacement [iskander.j@2020-11-03T12:00:01]")
+	if reason != "memory replacement" || user != "iskander.j" ||
+		since != time.Date(2020, 11, 3, 12, 0, 1, 0, time.Local) {
+		t.Errorf("Unexpected reason %q by %q at %s", reason, user, since)
+	}
+	// a reason set without scontrol has no suffix
+	reason, user, since = parseReason("Not responding")
+	if reason != "Not responding" || user != "" || !since.IsZero() {
+		t.Errorf("Unexpected reason %q by %q at %s", reason, user, since)
+	}
+}
+
+func TestNodeStateLong(t *testing.T) {
+	for state, expected := range map[string]string{
+		"MIXED":                "mixed",
+		"ALLOCATED+COMPLETING": "completing",
+		"MIXED+DRAIN":          "draining",
+		"IDLE+DRAIN":           "drained",
+		"IDLE+MAINT":           "maint",
+		"DOWN*":                "down*",
+		"IDLE+RESERVED":        "reserved",
+	} {
+		base, flags := parseNodeState(state)
+		node := Node{State: base, Flags: flags}
+		if s := node.StateLong(); s != expected {
+			t.Errorf("Expected %s to be %s, got %s", state, expected, s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+/*
+ * All job based collectors (queue, accounts, users, partitions) read the
+ * same list of jobs, squeue is executed only once per scrape.
+ */
+
+// Job as listed by squeue
+type Job struct {
+	ID         string
+	User       string
+	Account    string
+	Partitions []string
+	State      string
+	CPUs       float64
+	Memory     float64 // minimum memory requested in MB
+	Reason     string
+}
+
+// Fields of the squeue output, the reason is last since it is free text
+const jobsFormat = "%A|%u|%a|%P|%T|%C|%m|%r"
+
+// Execute the squeue command and return its output
+func JobsData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "squeue", "-a", "-r", "-h", "-o", jobsFormat, "--states=all")
+}
+
+// ParseJobs reads the jobs from the squeue output
+func ParseJobs(input []byte) []Job {
+	jobs := []Job{}
+	for _, line := range strings.Split(string(input), "\n") {
+		if !strings.Contains(line, "|") {
+			continue
+		}
+		fields := strings.SplitN(line, "|", 8)
+		if len(fields) < 8 {
+			parseError("ParseJobs", line)
+			continue
+		}
+		jobs = append(jobs, Job{
+			ID:         strings.TrimSpace(fields[0]),
+			User:       fields[1],
+			Account:    fields[2],
+			Partitions: strings.Split(fields[3], ","),
+			State:      strings.ToUpper(fields[4]),
+			CPUs:       parseFloat("ParseJobs", fields[5]),
+			Memory:     parseMemory("ParseJobs", fields[6]),
+			Reason:     strings.TrimSpace(fields[7]),
+		})
+	}
+	return jobs
+}
+
+// Convert a Slurm memory size like 90G or 4000M to MB, plain numbers
+// are already in MB
+func parseMemory(parser string, s string) float64 {
+	s = strings.TrimSpace(s)
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "T"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "M"):
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1.0 / 1024
+	default:
+		return parseFloat(parser, s)
+	}
+	return parseFloat(parser, s[:len(s)-1]) * multiplier
+}
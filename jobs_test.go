@@ -0,0 +1,57 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Jobs of the squeue test data
+func readJobs(t *testing.T) []Job {
+	data, err := ioutil.ReadFile("test_data/squeue.txt")
+	if err != nil {
+		t.Fatalf("Can not open test data: %v", err)
+	}
+	return ParseJobs(data)
+}
+
+func TestParseJobs(t *testing.T) {
+	jobs := readJobs(t)
+	if len(jobs) != 110 {
+		t.Fatalf("Expected 110 jobs, got %d", len(jobs))
+	}
+	job := jobs[0]
+	if job.ID != "1017242" || job.User != "bedo.j" || job.Account != "bioinf" ||
+		job.State != "PENDING" || job.CPUs != 24 || job.Memory != 90*1024 ||
+		job.Reason != "QOSMaxCpuPerUserLimit" || len(job.Partitions) != 1 || job.Partitions[0] != "regular" {
+		t.Errorf("Unexpected job %+v", job)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for input, expected := range map[string]float64{
+		"4000M": 4000,
+		"90G":   92160,
+		"1T":    1048576,
+		"2048K": 2,
+		"512":   512,
+	} {
+		if v := parseMemory("TestParseMemory", input); v != expected {
+			t.Errorf("Expected %s to be %v MB, got %v", input, expected, v)
+		}
+	}
+}
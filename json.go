@@ -0,0 +1,102 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+/*
+ * Since Slurm 21.08 squeue, sinfo and sdiag print JSON with --json, the
+ * documents are the same as the responses of slurmrestd and are decoded
+ * into the same structs.
+ */
+
+// Execute squeue with JSON output
+func JobsJSONData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "squeue", "-a", "--states=all", "--json")
+}
+
+// Execute sinfo with JSON output, the nodes are listed like scontrol
+func NodesJSONData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "sinfo", "--json")
+}
+
+// Execute sdiag with JSON output
+func SchedulerJSONData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "sdiag", "--json")
+}
+
+// ParseJobsJSON reads the jobs from the squeue JSON output
+func ParseJobsJSON(input []byte) ([]Job, error) {
+	var resp jobsResponse
+	if err := json.Unmarshal(input, &resp); err != nil {
+		parseErrors.WithLabelValues("ParseJobsJSON").Inc()
+		return nil, fmt.Errorf("can not parse the squeue JSON output: %v", err)
+	}
+	return resp.jobs(), nil
+}
+
+// ParseNodesJSON reads the nodes from the sinfo JSON output
+func ParseNodesJSON(input []byte) ([]Node, error) {
+	var resp nodesResponse
+	if err := json.Unmarshal(input, &resp); err != nil {
+		parseErrors.WithLabelValues("ParseNodesJSON").Inc()
+		return nil, fmt.Errorf("can not parse the sinfo JSON output: %v", err)
+	}
+	return resp.nodes(), nil
+}
+
+// ParseSchedulerJSON reads the statistics from the sdiag JSON output
+func ParseSchedulerJSON(input []byte) (*SchedulerMetrics, error) {
+	var resp diagResponse
+	if err := json.Unmarshal(input, &resp); err != nil {
+		parseErrors.WithLabelValues("ParseSchedulerJSON").Inc()
+		return nil, fmt.Errorf("can not parse the sdiag JSON output: %v", err)
+	}
+	return resp.Statistics.metrics(), nil
+}
+
+// Version printed by the Slurm commands, e.g. slurm 21.08.8
+var slurmVersion = regexp.MustCompile(`slurm (\d+)\.(\d+)`)
+
+// Returns true if the version printed by sinfo --version supports --json
+// in the v0.0.37 and v0.0.38 schema decoded by the exporter. Since 23.02
+// the output is produced by the data_parser plugins, which print the
+// state as a list and numbers as objects, those releases use the text
+// output in auto mode.
+func jsonSupported(version string) bool {
+	m := slurmVersion.FindStringSubmatch(version)
+	if m == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return (major == 21 && minor >= 8) || major == 22
+}
+
+// Detect whether the installed Slurm commands support --json
+func detectJSON(ctx context.Context) bool {
+	out, err := RunCommand(withoutHealth(ctx), "sinfo", "--version")
+	if err != nil {
+		return false
+	}
+	return jsonSupported(string(out))
+}
@@ -0,0 +1,109 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJSONSupported(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"slurm 20.11.8\n": false,
+		"slurm 21.08.8\n": true,
+		"slurm 22.05.9\n": true,
+		"slurm 23.02.1\n": false,
+		"slurm 24.05.0\n": false,
+		"":                false,
+	} {
+		if jsonSupported(version) != expected {
+			t.Errorf("Expected JSON support of %q to be %v", version, expected)
+		}
+	}
+}
+
+func TestCommandSourceJSON(t *testing.T) {
+	useRunner(t, fakeRunner{
+		"squeue": "test_data/slurmrestd/jobs.json",
+		"sinfo":  "test_data/slurmrestd/nodes.json",
+		"sdiag":  "test_data/slurmrestd/diag.json",
+	})
+	s := NewCommandSource(true)
+	jobs, err := s.Jobs(context.Background())
+	if err != nil || len(jobs) != 7 {
+		t.Errorf("Expected 7 jobs, got %d: %v", len(jobs), err)
+	}
+	nodes, err := s.Nodes(context.Background())
+	if err != nil || len(nodes) != 4 {
+		t.Errorf("Expected 4 nodes, got %d: %v", len(nodes), err)
+	}
+	sm, err := s.Diag(context.Background())
+	if err != nil || sm.threads != 3 {
+		t.Errorf("Unexpected statistics %+v: %v", sm, err)
+	}
+	if !s.JSON() {
+		t.Errorf("Expected the JSON output to be used")
+	}
+}
+
+func TestCommandSourceFallback(t *testing.T) {
+	// squeue prints text only, as before Slurm 21.08
+	useRunner(t, fakeRunner{"squeue": "test_data/squeue.txt"})
+	s := NewCommandSource(true)
+	jobs, err := s.Jobs(context.Background())
+	if err != nil || len(jobs) != 110 {
+		t.Errorf("Expected 110 jobs from the text output, got %d: %v", len(jobs), err)
+	}
+	if s.JSON() {
+		t.Errorf("Expected the source to fall back to the text output")
+	}
+}
+
+func TestCommandSourceNoFallbackOnTimeout(t *testing.T) {
+	calls := 0
+	useRunner(t, funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls++
+		return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: context.DeadlineExceeded}
+	}))
+	s := NewCommandSource(true)
+	if _, err := s.Jobs(context.Background()); err == nil {
+		t.Errorf("Expected the timeout to be returned")
+	}
+	if calls != 1 || !s.JSON() {
+		t.Errorf("Expected no fallback after a timeout, got %d calls", calls)
+	}
+	if _, err := s.Nodes(context.Background()); err == nil || !s.JSON() {
+		t.Errorf("Expected no fallback after a timeout: %v", err)
+	}
+}
+
+func TestCommandSourceRejectsJSON(t *testing.T) {
+	useRunner(t, funcRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if args[len(args)-1] == "--json" {
+			return nil, &CommandError{Command: name, Args: args, ExitCode: 1, Err: errors.New("exit status 1")}
+		}
+		return ioutil.ReadFile("test_data/squeue.txt")
+	}))
+	s := NewCommandSource(true)
+	if jobs, err := s.Jobs(context.Background()); err != nil || len(jobs) != 110 {
+		t.Errorf("Expected 110 jobs from the text output, got %d: %v", len(jobs), err)
+	}
+	if s.JSON() {
+		t.Errorf("Expected the source to fall back to the text output")
+	}
+}
@@ -0,0 +1,58 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// Landing page listing the endpoints and the enabled collectors
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Prometheus Slurm Exporter</title></head>
+<body>
+<h1>Prometheus Slurm Exporter</h1>
+<ul>
+<li><a href="/metrics">Metrics</a></li>
+<li><a href="/probe">Probe</a></li>
+<li><a href="/-/healthy">Health</a></li>
+<li><a href="/-/ready">Readiness</a></li>
+</ul>
+<h2>Enabled collectors</h2>
+<ul>
+{{range .Collectors}}<li>{{.}}</li>
+{{end}}</ul>
+{{if .Clusters}}<h2>Clusters</h2>
+<ul>
+{{range .Clusters}}<li><a href="/probe?cluster={{.Name}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+// Serves the landing page of the exporter on / and 404 on all other
+// unknown paths
+func (e *Exporter) ServeLanding(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	landingTemplate.Execute(w, struct {
+		Collectors []string
+		Clusters   []Cluster
+	}{e.names, e.cfg.Clusters})
+}
@@ -0,0 +1,46 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/promlog"
+)
+
+// Minimum level and output format of the log
+var (
+	logLevel  = &promlog.AllowedLevel{}
+	logFormat = &promlog.AllowedFormat{}
+)
+
+// Logger used by the whole exporter, replaced in main() once the flags
+// are parsed
+var logger log.Logger
+
+func init() {
+	logLevel.Set("info")
+	logFormat.Set("logfmt")
+	flag.Var(logLevel, "log.level", "Only log messages with the given severity or above, one of debug, info, warn or error.")
+	flag.Var(logFormat, "log.format", "Output format of the log, logfmt or json.")
+	logger = newLogger()
+}
+
+// Create a leveled logger writing to stderr in the configured format
+func newLogger() log.Logger {
+	return promlog.New(&promlog.Config{Level: logLevel, Format: logFormat})
+}
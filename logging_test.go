@@ -0,0 +1,65 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Write the log in logfmt into a buffer for the duration of a test
+func useLogger(t *testing.T, allow level.Option) *bytes.Buffer {
+	var buf bytes.Buffer
+	old := logger
+	logger = level.NewFilter(log.NewLogfmtLogger(&buf), allow)
+	t.Cleanup(func() { logger = old })
+	return &buf
+}
+
+func TestLogLevelFlag(t *testing.T) {
+	defer logLevel.Set(logLevel.String())
+	if err := logLevel.Set("trace"); err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+	if err := logFormat.Set("text"); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+	for _, lvl := range []string{"debug", "info", "warn", "error"} {
+		if err := logLevel.Set(lvl); err != nil {
+			t.Errorf("Level %s rejected: %v", lvl, err)
+		}
+	}
+}
+
+func TestParseErrorLogged(t *testing.T) {
+	buf := useLogger(t, level.AllowWarn())
+	parseError("TestParseErrorLogged", "garbage|line")
+	parseFloat("TestParseErrorLogged", "42G")
+	out := buf.String()
+	for _, want := range []string{
+		`level=warn`,
+		`parser=TestParseErrorLogged line=garbage|line`,
+		`value=42G`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the log, got %s", want, out)
+		}
+	}
+}
@@ -16,37 +16,421 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
 )
 
 func init() {
-	// Metrics have to be registered to be exposed
-	prometheus.MustRegister(NewSchedulerCollector())  // from scheduler.go
-	prometheus.MustRegister(NewQueueCollector())      // from queue.go
-	prometheus.MustRegister(NewNodesCollector())      // from nodes.go
-	prometheus.MustRegister(NewNodesInfoCollector())  // from nodesinfo.go
-	prometheus.MustRegister(NewCPUsCollector())       // from cpus.go
-	prometheus.MustRegister(NewAccountsCollector())   // from accounts.go
-	prometheus.MustRegister(NewUsersCollector())      // from users.go
-	prometheus.MustRegister(NewPartitionsCollector()) // from partitions.go
-	//prometheus.MustRegister(NewFSCollector())         // from filesystem.go
+	prometheus.MustRegister(collectErrors)
+	prometheus.MustRegister(collectTimeouts)
+	prometheus.MustRegister(parseErrors)
+	prometheus.MustRegister(commandDuration)
+	prometheus.MustRegister(commandExecutions)
+	prometheus.MustRegister(circuitOpen)
+	prometheus.MustRegister(droppedSeries)
 }
 
+var configFile = flag.String(
+	"config.file",
+	"",
+	"YAML configuration file, command line flags take precedence over its values.")
+
+var webConfigFile = flag.String(
+	"web.config.file",
+	"",
+	"Web configuration file with TLS and authentication settings in the format of the Prometheus exporter-toolkit.")
+
 var listenAddress = flag.String(
 	"listen-address",
 	":8080",
 	"The address to listen on for HTTP requests.")
 
+var commandTimeout = flag.Duration(
+	"command-timeout",
+	30*time.Second,
+	"Maximum time a Slurm command may run before it is killed.")
+
+var replayDir = flag.String(
+	"replay-dir",
+	"",
+	"Read the output of the Slurm commands from <command>.txt files in this directory instead of executing them.")
+
+var recordDir = flag.String(
+	"record-dir",
+	"",
+	"Run the enabled collectors once, write the output of the Slurm commands into a bundle in this directory and exit.")
+
+var recordScrub = flag.Bool(
+	"record-scrub",
+	false,
+	"Replace user and account names in the bundle written by -record-dir.")
+
+var commandOutput = flag.String(
+	"command-output",
+	"auto",
+	"Output format of the Slurm commands: json, text or auto to use json with Slurm 21.08 and 22.05.")
+
+var clusterNames = flag.String(
+	"clusters",
+	"",
+	"Comma separated list of clusters to collect with -M <cluster>, each metric gets a cluster label.")
+
+var restURL = flag.String(
+	"slurmrestd.url",
+	"",
+	"Read the Slurm data from slurmrestd at this URL, e.g. http://localhost:6820, instead of executing the Slurm commands.")
+
+var restVersion = flag.String(
+	"slurmrestd.api-version",
+	"v0.0.37",
+	"Version of the slurmrestd API.")
+
+var restUser = flag.String(
+	"slurmrestd.user",
+	"",
+	"User name sent to slurmrestd with the token.")
+
+var restTokenFile = flag.String(
+	"slurmrestd.token-file",
+	"",
+	"File with the JWT token for slurmrestd, read on every request. Defaults to the SLURM_JWT environment variable.")
+
+var readyFailures = flag.Int(
+	"web.ready-failures",
+	3,
+	"Number of failures in a row of every Slurm command of a cluster after which /-/ready reports not ready, at least 1.")
+
+var enableLifecycle = flag.Bool(
+	"web.enable-lifecycle",
+	false,
+	"Reload the configuration on POST requests to /-/reload.")
+
+var commandConcurrency = flag.Int(
+	"command-concurrency",
+	0,
+	"Maximum number of Slurm commands running at the same time, 0 for no limit.")
+
+var commandMinInterval = flag.Duration(
+	"command-min-interval",
+	0,
+	"Minimum time between two runs of the same Slurm command, the previous output is served in between.")
+
+var breakerFailures = flag.Int(
+	"circuit-breaker.failures",
+	0,
+	"Timeouts in a row of one Slurm command after which the commands of its cluster are suspended and the last output is served, 0 disables the circuit breaker.")
+
+var breakerBackoff = flag.Duration(
+	"circuit-breaker.backoff",
+	time.Minute,
+	"Time the Slurm commands of a cluster stay suspended.")
+
+var timeoutOffset = flag.Duration(
+	"web.timeout-offset",
+	500*time.Millisecond,
+	"Subtracted from the scrape timeout sent by Prometheus to leave time for sending the metrics.")
+
+var nodesInfoLegacy = flag.Bool(
+	"collector.nodesinfo.legacy-metrics",
+	false,
+	"Also export slurm_node_freemem, slurm_node_allocmem and slurm_node_cpuload with the node attributes as labels, deprecated and removed in the next release.")
+
+var pollInterval = flag.Duration(
+	"poll-interval",
+	0,
+	"Refresh the Slurm metrics in the background on this interval and serve the last snapshot, 0 collects on every scrape.")
+
 func main() {
 	flag.Parse()
+	logger = newLogger()
+	var webCfg *WebConfig
+	if *webConfigFile != "" {
+		var err error
+		if webCfg, err = LoadWebConfig(*webConfigFile); err != nil {
+			level.Error(logger).Log("msg", "Can not load the web configuration", "err", err)
+			os.Exit(1)
+		}
+	}
+	cfg, settings, err := loadConfig()
+	if err != nil {
+		level.Error(logger).Log("msg", "Can not load the configuration", "err", err)
+		os.Exit(1)
+	}
+	settings.apply()
+	if *recordDir != "" && *restURL != "" {
+		level.Error(logger).Log("msg", "Record mode captures the Slurm commands and can not be used with slurmrestd")
+		os.Exit(1)
+	}
+	if *replayDir != "" {
+		level.Info(logger).Log("msg", "Replaying Slurm command output", "dir", *replayDir)
+		setRunner(NewInstrumentedRunner(NewReplayRunner(*replayDir)))
+	} else {
+		setRunner(newRunner(cfg))
+	}
+	if *restURL != "" {
+		level.Info(logger).Log("msg", "Reading Slurm data from slurmrestd", "url", *restURL)
+		rest := NewRESTSource(*restURL, *restVersion, *commandTimeout)
+		rest.User = *restUser
+		rest.Token = os.Getenv("SLURM_JWT")
+		rest.TokenFile = *restTokenFile
+		source = rest
+	} else {
+		json := false
+		switch *commandOutput {
+		case "json":
+			json = true
+		case "text":
+		case "auto":
+			// the Slurm version is not replayed, the bundle knows its format
+			if *replayDir != "" {
+				json = replayJSON(*replayDir)
+			} else {
+				json = detectJSON(context.Background())
+			}
+		default:
+			level.Error(logger).Log("msg", "Unknown command output, expected json, text or auto", "command_output", *commandOutput)
+			os.Exit(1)
+		}
+		if json {
+			level.Info(logger).Log("msg", "Reading the JSON output of the Slurm commands")
+		}
+		source = NewCommandSource(json)
+	}
+	if *readyFailures < 1 {
+		level.Error(logger).Log("msg", "-web.ready-failures must be at least 1", "ready_failures", *readyFailures)
+		os.Exit(1)
+	}
+	health = NewHealth(*readyFailures)
+	if *recordDir != "" {
+		bundle, err := Record(*recordDir, enabledCollectors(), cfg.Clusters, *recordScrub)
+		if err != nil {
+			level.Error(logger).Log("msg", "Recording the Slurm command output failed", "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", "Slurm command output written", "dir", bundle)
+		return
+	}
+	// Metrics have to be registered to be exposed
+	exporter, err := newExporter(cfg, settings)
+	if err != nil {
+		level.Error(logger).Log("msg", "Can not register the collectors", "err", err)
+		os.Exit(1)
+	}
+	srv := NewServer(exporter, reloadExporter)
 	// The Handler function provides a default handler to expose metrics
 	// via an HTTP server. "/metrics" is the usual endpoint for that.
-	log.Infof("Starting Server: %s", *listenAddress)
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	level.Info(logger).Log("msg", "Starting server", "address", *listenAddress)
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, http.HandlerFunc(srv.ServeMetrics)))
+	http.HandleFunc("/probe", srv.ServeProbe)
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.HandleFunc("/-/ready", readyHandler)
+	if *enableLifecycle {
+		http.HandleFunc("/-/reload", srv.ServeReload)
+	}
+	http.HandleFunc("/", srv.ServeLanding)
+	server := &http.Server{Addr: *listenAddress, Handler: http.DefaultServeMux}
+
+	// SIGHUP reloads the configuration, SIGTERM and SIGINT stop the
+	// running Slurm commands and wait for the running requests
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				if err := srv.Reload(); err != nil {
+					level.Error(logger).Log("msg", "Reloading the configuration failed", "err", err)
+				} else {
+					level.Info(logger).Log("msg", "Configuration reloaded")
+				}
+				continue
+			}
+			level.Info(logger).Log("msg", "Shutting down", "signal", sig)
+			cancelCollections()
+			srv.Stop()
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := server.Shutdown(ctx); err != nil {
+				level.Error(logger).Log("msg", "Shutdown failed", "err", err)
+			}
+			cancel()
+			close(done)
+			return
+		}
+	}()
+	if err := ListenAndServe(server, webCfg); err != http.ErrServerClosed {
+		level.Error(logger).Log("msg", "Server failed", "err", err)
+		os.Exit(1)
+	}
+	<-done
+}
+
+// Time the running requests have to finish on shutdown, the Slurm
+// commands are already stopped
+const shutdownTimeout = 10 * time.Second
+
+// Read the configuration file, without one the defaults and command line
+// flags are used. The settings are not applied yet.
+func loadConfig() (*Config, *settings, error) {
+	cfg := &Config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = LoadConfig(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	if *clusterNames != "" {
+		cfg.Clusters = nil
+		for _, name := range strings.Split(*clusterNames, ",") {
+			cfg.Clusters = append(cfg.Clusters, Cluster{Name: strings.TrimSpace(name)})
+		}
+	}
+	if *restURL != "" && len(cfg.Clusters) > 0 {
+		return nil, nil, fmt.Errorf("clusters can not be collected from slurmrestd, use one exporter per cluster")
+	}
+	return cfg, configSettings(cfg), nil
+}
+
+// Create the exporter for the configuration and its settings
+func newExporter(cfg *Config, s *settings) (*Exporter, error) {
+	e, err := NewExporter(cfg, s.enabledCollectors(), s.pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(logger).Log("msg", "Enabled collectors", "collectors", strings.Join(e.names, ","))
+	for _, cluster := range cfg.Clusters {
+		level.Info(logger).Log("msg", "Collecting cluster", "cluster", cluster.Name)
+	}
+	if s.pollInterval > 0 {
+		level.Info(logger).Log("msg", "Polling Slurm", "interval", s.pollInterval)
+	}
+	return e, nil
+}
+
+// Read the configuration file again and create a new exporter for it, the
+// settings are only applied once the exporter has been created
+func reloadExporter() (*Exporter, error) {
+	cfg, settings, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	e, err := newExporter(cfg, settings)
+	if err != nil {
+		return nil, err
+	}
+	settings.apply()
+	if *replayDir == "" {
+		setRunner(newRunner(cfg))
+	}
+	return e, nil
+}
+
+// Values of the flags which can be set in the configuration file
+type settings struct {
+	collectors     map[string]bool
+	commandTimeout time.Duration
+	pollInterval   time.Duration
+}
+
+// Settings of the configuration file for the flags which are not set on
+// the command line, settings missing in the file return to their
+// defaults. The flags are not changed.
+func configSettings(cfg *Config) *settings {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	s := &settings{
+		collectors:     map[string]bool{},
+		commandTimeout: *commandTimeout,
+		pollInterval:   *pollInterval,
+	}
+	for name, entry := range collectors {
+		s.collectors[name] = entry.enabled
+		if set["collector."+name] || set["no-collector."+name] {
+			continue
+		}
+		s.collectors[name] = entry.defaultEnabled
+		if enabled, ok := cfg.Collectors[name]; ok {
+			s.collectors[name] = enabled
+		}
+	}
+	if !set["command-timeout"] {
+		s.commandTimeout = defaultDuration("command-timeout")
+		if cfg.CommandTimeout > 0 {
+			s.commandTimeout = cfg.CommandTimeout
+		}
+	}
+	if !set["poll-interval"] {
+		s.pollInterval = defaultDuration("poll-interval")
+		if cfg.PollInterval > 0 {
+			s.pollInterval = cfg.PollInterval
+		}
+	}
+	return s
+}
+
+// Names of the collectors enabled by the settings in alphabetical order
+func (s *settings) enabledCollectors() []string {
+	names := []string{}
+	for name, enabled := range s.collectors {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set the flags to the settings
+func (s *settings) apply() {
+	for name, enabled := range s.collectors {
+		collectors[name].enabled = enabled
+	}
+	*commandTimeout = s.commandTimeout
+	*pollInterval = s.pollInterval
+}
+
+// Default value of a duration flag
+func defaultDuration(name string) time.Duration {
+	d, _ := time.ParseDuration(flag.Lookup(name).DefValue)
+	return d
+}
+
+// Runner executing the Slurm commands of the configuration, instrumented
+// and guarded by the concurrency limit and circuit breaker
+func newRunner(cfg *Config) CommandRunner {
+	// the circuits of the previous runner are gone
+	circuitOpen.Reset()
+	r := NewGuardedRunner(NewInstrumentedRunner(newExecRunner(cfg)), *commandConcurrency)
+	r.MinInterval = *commandMinInterval
+	r.Failures = *breakerFailures
+	r.Backoff = *breakerBackoff
+	return r
+}
+
+// Runner executing the Slurm commands with the paths, timeouts and
+// slurm.conf of the configuration
+func newExecRunner(cfg *Config) *ExecRunner {
+	r := NewExecRunner(*commandTimeout)
+	r.Paths = map[string]string{}
+	r.Timeouts = map[string]time.Duration{}
+	for name, cmd := range cfg.Commands {
+		r.Paths[name] = cmd.Path
+		r.Timeouts[name] = cmd.Timeout
+	}
+	if cfg.SlurmConf != "" {
+		r.Env = []string{"SLURM_CONF=" + cfg.SlurmConf}
+	}
+	return r
 }
@@ -17,15 +17,13 @@ package main
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"io/ioutil"
-	"log"
-	"os/exec"
 	"regexp"
-	"sort"
-	"strconv"
-	"strings"
 )
 
+func init() {
+	registerCollector("nodes", true, func() Collector { return NewNodesCollector() })
+}
+
 type NodesMetrics struct {
 	alloc float64
 	comp  float64
@@ -39,90 +37,82 @@ type NodesMetrics struct {
 	resv  float64
 }
 
-func NodesGetMetrics() *NodesMetrics {
-	return ParseNodesMetrics(NodesData())
-}
-
-func RemoveDuplicates(s []string) []string {
-	m := map[string]bool{}
-	t := []string{}
-
-	// Walk through the slice 's' and for each value we haven't seen so far, append it to 't'.
-	for _, v := range s {
-		if _, seen := m[v]; !seen {
-			t = append(t, v)
-			m[v] = true
+// Count the nodes in each state, every node is counted once even if it
+// is a member of several partitions
+func ParseNodesMetrics(nodes []Node) *NodesMetrics {
+	var nm NodesMetrics
+	alloc := regexp.MustCompile(`^alloc`)
+	comp := regexp.MustCompile(`^comp`)
+	down := regexp.MustCompile(`^down`)
+	drain := regexp.MustCompile(`^drain`)
+	fail := regexp.MustCompile(`^fail`)
+	err := regexp.MustCompile(`^err`)
+	idle := regexp.MustCompile(`^idle`)
+	maint := regexp.MustCompile(`^maint`)
+	mix := regexp.MustCompile(`^mix`)
+	resv := regexp.MustCompile(`^res`)
+	for _, node := range nodes {
+		state := node.StateLong()
+		switch {
+		case alloc.MatchString(state) == true:
+			nm.alloc++
+		case comp.MatchString(state) == true:
+			nm.comp++
+		case down.MatchString(state) == true:
+			nm.down++
+		case drain.MatchString(state) == true:
+			nm.drain++
+		case fail.MatchString(state) == true:
+			nm.fail++
+		case err.MatchString(state) == true:
+			nm.err++
+		case idle.MatchString(state) == true:
+			nm.idle++
+		case maint.MatchString(state) == true:
+			nm.maint++
+		case mix.MatchString(state) == true:
+			nm.mix++
+		case resv.MatchString(state) == true:
+			nm.resv++
 		}
 	}
+	return &nm
+}
 
-	return t
+// NodeStateKey is a base state with the flags of a node
+type NodeStateKey struct {
+	state string
+	flags string // joined by a plus sign
 }
 
-func ParseNodesMetrics(input []byte) *NodesMetrics {
-	var nm NodesMetrics
-	lines := strings.Split(string(input), "\n")
-
-	// Sort and remove all the duplicates from the 'sinfo' output
-	sort.Strings(lines)
-	lines_uniq := RemoveDuplicates(lines)
-
-	for _, line := range lines_uniq {
-		if strings.Contains(line, ",") {
-			split := strings.Split(line, ",")
-			count, _ := strconv.ParseFloat(strings.TrimSpace(split[0]), 64)
-			state := split[1]
-			alloc := regexp.MustCompile(`^alloc`)
-			comp := regexp.MustCompile(`^comp`)
-			down := regexp.MustCompile(`^down`)
-			drain := regexp.MustCompile(`^drain`)
-			fail := regexp.MustCompile(`^fail`)
-			err := regexp.MustCompile(`^err`)
-			idle := regexp.MustCompile(`^idle`)
-			maint := regexp.MustCompile(`^maint`)
-			mix := regexp.MustCompile(`^mix`)
-			resv := regexp.MustCompile(`^res`)
-			switch {
-			case alloc.MatchString(state) == true:
-				nm.alloc += count
-			case comp.MatchString(state) == true:
-				nm.comp += count
-			case down.MatchString(state) == true:
-				nm.down += count
-			case drain.MatchString(state) == true:
-				nm.drain += count
-			case fail.MatchString(state) == true:
-				nm.fail += count
-			case err.MatchString(state) == true:
-				nm.err += count
-			case idle.MatchString(state) == true:
-				nm.idle += count
-			case maint.MatchString(state) == true:
-				nm.maint += count
-			case mix.MatchString(state) == true:
-				nm.mix += count
-			case resv.MatchString(state) == true:
-				nm.resv += count
-			}
-		}
+// ParseNodeStates counts the nodes by base state and flags, every node
+// is counted once
+func ParseNodeStates(nodes []Node) map[NodeStateKey]float64 {
+	states := map[NodeStateKey]float64{}
+	for _, node := range nodes {
+		states[NodeStateKey{node.State, joinFlags(node.Flags)}]++
 	}
-	return &nm
+	return states
 }
 
-// Execute the sinfo command and return its output
-func NodesData() []byte {
-	cmd := exec.Command("sinfo", "-h", "-o %D,%T")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
+// PartitionNodeStateKey is a base state with the flags of the nodes of a
+// partition
+type PartitionNodeStateKey struct {
+	partition string
+	NodeStateKey
+}
+
+// ParsePartitionNodeStates counts the nodes of each partition by base
+// state and flags, a node is counted in every partition it is a member of
+func ParsePartitionNodeStates(nodes []Node) map[PartitionNodeStateKey]float64 {
+	states := map[PartitionNodeStateKey]float64{}
+	for _, node := range nodes {
+		key := NodeStateKey{node.State, joinFlags(node.Flags)}
+		for _, partition := range node.Partitions {
+			states[PartitionNodeStateKey{partition, key}]++
+		}
 	}
-	return out
+	return states
 }
 
 /*
@@ -143,20 +133,26 @@ func NewNodesCollector() *NodesCollector {
 		maint: prometheus.NewDesc("slurm_nodes_maint", "Maint nodes", nil, nil),
 		mix:   prometheus.NewDesc("slurm_nodes_mix", "Mix nodes", nil, nil),
 		resv:  prometheus.NewDesc("slurm_nodes_resv", "Reserved nodes", nil, nil),
+		state: prometheus.NewDesc("slurm_nodes", "Nodes by base state and state flags, several flags are joined by a plus sign",
+			[]string{"state", "flag"}, nil),
+		partition: prometheus.NewDesc("slurm_partition_nodes", "Nodes of a partition by base state and state flags, several flags are joined by a plus sign",
+			[]string{"partition", "state", "flag"}, nil),
 	}
 }
 
 type NodesCollector struct {
-	alloc *prometheus.Desc
-	comp  *prometheus.Desc
-	down  *prometheus.Desc
-	drain *prometheus.Desc
-	err   *prometheus.Desc
-	fail  *prometheus.Desc
-	idle  *prometheus.Desc
-	maint *prometheus.Desc
-	mix   *prometheus.Desc
-	resv  *prometheus.Desc
+	alloc     *prometheus.Desc
+	comp      *prometheus.Desc
+	down      *prometheus.Desc
+	drain     *prometheus.Desc
+	err       *prometheus.Desc
+	fail      *prometheus.Desc
+	idle      *prometheus.Desc
+	maint     *prometheus.Desc
+	mix       *prometheus.Desc
+	resv      *prometheus.Desc
+	state     *prometheus.Desc
+	partition *prometheus.Desc
 }
 
 // Send all metric descriptions
@@ -171,9 +167,15 @@ func (nc *NodesCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nc.maint
 	ch <- nc.mix
 	ch <- nc.resv
+	ch <- nc.state
+	ch <- nc.partition
 }
-func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
-	nm := NodesGetMetrics()
+func (nc *NodesCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	nodes, err := scrape.Nodes()
+	if err != nil {
+		return err
+	}
+	nm := ParseNodesMetrics(nodes)
 	ch <- prometheus.MustNewConstMetric(nc.alloc, prometheus.GaugeValue, nm.alloc)
 	ch <- prometheus.MustNewConstMetric(nc.comp, prometheus.GaugeValue, nm.comp)
 	ch <- prometheus.MustNewConstMetric(nc.down, prometheus.GaugeValue, nm.down)
@@ -184,4 +186,11 @@ func (nc *NodesCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(nc.maint, prometheus.GaugeValue, nm.maint)
 	ch <- prometheus.MustNewConstMetric(nc.mix, prometheus.GaugeValue, nm.mix)
 	ch <- prometheus.MustNewConstMetric(nc.resv, prometheus.GaugeValue, nm.resv)
+	for key, count := range ParseNodeStates(nodes) {
+		ch <- prometheus.MustNewConstMetric(nc.state, prometheus.GaugeValue, count, key.state, key.flags)
+	}
+	for key, count := range ParsePartitionNodeStates(nodes) {
+		ch <- prometheus.MustNewConstMetric(nc.partition, prometheus.GaugeValue, count, key.partition, key.state, key.flags)
+	}
+	return nil
 }
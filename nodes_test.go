@@ -16,21 +16,47 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"os"
+	"reflect"
 	"testing"
 )
 
 func TestNodesMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/sinfo.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
+	nm := ParseNodesMetrics(readNodes(t))
+	t.Logf("%+v", nm)
+	expected := NodesMetrics{alloc: 1, comp: 1, down: 1, drain: 2, idle: 3, maint: 1, mix: 30}
+	if *nm != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *nm)
 	}
-	data, err := ioutil.ReadAll(file)
-	t.Logf("%+v", ParseNodesMetrics(data))
 }
 
-func TestNodesGetMetrics(t *testing.T) {
-	t.Logf("%+v", NodesGetMetrics())
+func TestParseNodeStates(t *testing.T) {
+	states := ParseNodeStates(readNodes(t))
+	expected := map[NodeStateKey]float64{
+		{"allocated", ""}:                1,
+		{"allocated", "completing"}:      1,
+		{"down", "not_responding"}:       1,
+		{"idle", ""}:                     3,
+		{"idle", "drain+not_responding"}: 1,
+		{"idle", "maint"}:                1,
+		{"mixed", ""}:                    30,
+		{"mixed", "drain"}:               1,
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("Expected %v, got %v", expected, states)
+	}
+}
+
+func TestParsePartitionNodeStates(t *testing.T) {
+	states := ParsePartitionNodeStates(readNodes(t))
+	totals := map[string]float64{}
+	for key, count := range states {
+		totals[key.partition] += count
+	}
+	expected := map[string]float64{"bigmem": 4, "gpuq": 5, "long": 30, "regular": 30}
+	if !reflect.DeepEqual(totals, expected) {
+		t.Errorf("Expected %v nodes per partition, got %v", expected, totals)
+	}
+	if n := states[PartitionNodeStateKey{"gpuq", NodeStateKey{"idle", "drain+not_responding"}}]; n != 1 {
+		t.Errorf("Expected one drained node not responding in gpuq, got %v", n)
+	}
 }
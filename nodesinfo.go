@@ -16,15 +16,16 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"log"
-	"os/exec"
 	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+func init() {
+	registerCollector("nodesinfo", true, func() Collector { return NewNodesInfoCollector() })
+}
+
 //NodesInfoMetrics struct with all info for individual nodes
 type NodesInfoMetrics struct {
 	freemem  float64
@@ -43,164 +44,101 @@ type MetricKey struct {
 	feature string
 }
 
-// NodesInfoData Execute the sinfo command and return its output
-func NodesInfoData() []byte {
-	//sinfo -e -N -h -o%n,%e,%m,%c,%O,%T,%b,%w
-	cmd := exec.Command("sinfo", "-h", "-e", "-N", "-o%n,%e,%m,%c,%O,%T,%b,%w")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
-}
-
-//NodesDataInfoData to execute a generic cmd that is sent as an argurment
-func NodesDataInfoData(cmd *exec.Cmd) []byte {
-	//sinfo -e -h -o%e,%T,%b
-	//cmd := exec.Command("sinfo", "-h", "-e", "-o%e,%T,%b")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
-}
-
-//ParseNodesInfoMetrics function parse return from Data function
-func ParseNodesInfoMetrics(input []byte) map[string]*NodesInfoMetrics {
+//ParseNodesInfoMetrics function returns the memory and load of the individual nodes
+func ParseNodesInfoMetrics(input []Node) map[string]*NodesInfoMetrics {
 	nodes := make(map[string]*NodesInfoMetrics)
-	//nodebytes := map[Key]float64{}
-	//nodecpus := map[Key]float64{}
-	lines := strings.Split(string(input), "\n")
-
-	for _, line := range lines {
-		if strings.Contains(line, ",") {
-
-			//node name
-			node := strings.Split(line, ",")[0]
-			_, key := nodes[node]
-			if !key {
-				nodes[node] = &NodesInfoMetrics{0, 0, "", "", 0, "", "", ""}
-			}
-			freemem, _ := strconv.ParseFloat(strings.Split(line, ",")[1], 64)
-			totalmem := strings.Split(line, ",")[2]
-			t, _ := strconv.ParseFloat(totalmem, 64)
-			allocmem := t - freemem
-			cpus := strings.Split(line, ",")[3]
-			cpuload, _ := strconv.ParseFloat(strings.Split(line, ",")[4], 64)
-			state := strings.Split(line, ",")[5]
-			feature := strings.Split(line, ",")[6]
-			weight := strings.Split(line, ",")[7]
-
-			nodes[node].freemem = freemem
-			nodes[node].totalmem = totalmem
-			nodes[node].allocmem = allocmem
-			nodes[node].cpus = cpus
-			nodes[node].cpuload = cpuload
-			nodes[node].state = state
-			nodes[node].feature = feature
-			nodes[node].weight = weight
-
+	for _, node := range input {
+		nodes[node.Name] = &NodesInfoMetrics{
+			freemem:  node.FreeMemory,
+			allocmem: node.RealMemory - node.FreeMemory,
+			totalmem: strconv.FormatFloat(node.RealMemory, 'f', -1, 64),
+			cpus:     strconv.FormatFloat(node.CPUTotal, 'f', -1, 64),
+			cpuload:  node.CPULoad,
+			state:    node.StateLong(),
+			feature:  node.ActiveFeatures,
+			weight:   node.Weight,
 		}
 	}
-
 	return nodes
 }
 
-/*ParseNodesDataMetrics function parse return from Data function
-and returns accumulative total grouped by feature and state
+/*ParseNodesDataMetrics function returns accumulative total of the
+memory grouped by feature and state: alloc, free, drained, maint and
+completing as well as the free memory of the mixed nodes
 */
-func ParseNodesDataMetrics(input []byte) map[MetricKey]float64 {
-	//log.Println(input)
+func ParseNodesDataMetrics(nodes []Node) map[MetricKey]float64 {
 	data := map[MetricKey]float64{}
-
-	lines := strings.Split(string(input), "\n")
-	//log.Println(lines)
-	//-OAllocMem:10:,FreeMem:10:,Memory:10:,StateLong:10:,Features:10,:free
-	for _, line := range lines {
-		if strings.Contains(line, ":") {
-
-			feature := strings.TrimSpace(strings.Split(line, ":")[3])
-			state := strings.TrimSpace(strings.Split(line, ":")[4])
-			alloc, _ := strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[0]), 64)
-			free, _ := strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
-			s := strings.TrimSpace(strings.Split(line, ":")[2])
-			_, ok := data[MetricKey{state, feature}]
-
-			if !ok {
-				data[MetricKey{state, feature}] = 0
-			}
-			if s == "mixed" {
-				data[MetricKey{"mixed_free", feature}] += free
-			}
-			if state == "drained" || state == "free" {
-				data[MetricKey{state, feature}] += free
-			} else {
-				data[MetricKey{state, feature}] += alloc
-			}
-
+	for _, node := range nodes {
+		feature := node.Features
+		busy := node.State == "allocated" || node.State == "mixed" || node.HasFlag("completing")
+		if node.State == "mixed" {
+			data[MetricKey{"mixed_free", feature}] += node.FreeMemory
+		}
+		if node.State == "allocated" || node.State == "mixed" {
+			data[MetricKey{"alloc", feature}] += node.AllocMemory
+		}
+		if node.State == "idle" && !node.HasFlag("drain") {
+			data[MetricKey{"free", feature}] += node.FreeMemory
+		}
+		if node.HasFlag("drain") && !busy {
+			data[MetricKey{"drained", feature}] += node.FreeMemory
+		}
+		if node.HasFlag("maint") {
+			data[MetricKey{"maint", feature}] += node.AllocMemory
+		}
+		if node.HasFlag("completing") {
+			data[MetricKey{"completing", feature}] += node.AllocMemory
 		}
 	}
 	return data
 }
 
-/*ParseNodesGPUMetrics function parse return from Data function
-and returns accumulative total of GPU used/available grouped by feature and state
+/*ParseNodesGPUMetrics function returns accumulative total of GPU
+used/available grouped by feature and state
 */
-func ParseNodesGPUMetrics(input []byte) map[MetricKey]float64 {
-	//log.Println(input)
+func ParseNodesGPUMetrics(nodes []Node) map[MetricKey]float64 {
 	data := map[MetricKey]float64{}
+	for _, node := range nodes {
+		feature := node.Features
+		gres, err := ParseGres(node.Gres)
+		if err != nil {
+			parseError("ParseNodesGPUMetrics", "NodeName="+node.Name+" Gres="+node.Gres)
+			continue
+		}
+		used, err := ParseGres(node.GresUsed)
+		if err != nil {
+			parseError("ParseNodesGPUMetrics", "NodeName="+node.Name+" GresUsed="+node.GresUsed)
+			continue
+		}
+		total := gresCount(gres, "gpu")
+		alloc := gresCount(used, "gpu")
 
-	lines := strings.Split(string(input), "\n")
-	for _, line := range lines {
-		//"-OGres:10-,GresUsed:10-,StateLong:10-,Features:10"
-		if strings.Contains(line, "-") {
-			alloc := 0.0
-			total := 0.0
-			feature := strings.TrimSpace(strings.Split(line, "-")[3])
-			if strings.TrimSpace(strings.Split(line, "-")[0]) != "(null)" {
-				temp := strings.Split(strings.TrimSpace(strings.Split(line, "-")[1]), ":")[2][0:1]
-				alloc, _ = strconv.ParseFloat(temp, 64)
-				temp = strings.Split(strings.TrimSpace(strings.Split(line, "-")[0]), ":")[2][0:1]
-				total, _ = strconv.ParseFloat(temp, 64)
-			}
-
-			state := strings.TrimSpace(strings.Split(line, "-")[2])
-			_, ok := data[MetricKey{state, feature}]
-
-			if !ok {
-				data[MetricKey{state, feature}] = 0
-			}
-			if state == "mixed" {
-				data[MetricKey{"free", feature}] += total - alloc
-			} else if state == "drained*" {
-				data[MetricKey{"drained", feature}] += total
-			} else if state == "idle" {
-				data[MetricKey{"free", feature}] += total
-			}
-			data[MetricKey{"alloc", feature}] += alloc
+		state := node.StateLong()
+		_, ok := data[MetricKey{state, feature}]
 
+		if !ok {
+			data[MetricKey{state, feature}] = 0
 		}
+		if state == "mixed" {
+			data[MetricKey{"free", feature}] += total - alloc
+		} else if state == "drained*" {
+			data[MetricKey{"drained", feature}] += total
+		} else if state == "idle" {
+			data[MetricKey{"free", feature}] += total
+		}
+		data[MetricKey{"alloc", feature}] += alloc
 	}
 	return data
 }
 
-//NodesInfoGetMetrics fun
-func NodesInfoGetMetrics() map[string]*NodesInfoMetrics {
-	return ParseNodesInfoMetrics(NodesInfoData())
+// Read a TRES list of a node, a list which can not be parsed is counted
+// and skipped
+func parseNodeTRES(node string, field string, s string) map[string]float64 {
+	tres, err := ParseTRES(s)
+	if err != nil {
+		parseError("ParseNodes", "NodeName="+node+" "+field+"="+s)
+	}
+	return tres
 }
 
 /*
@@ -213,100 +151,152 @@ func NodesInfoGetMetrics() map[string]*NodesInfoMetrics {
 func NewNodesInfoCollector() *NodesInfoCollector {
 	labels := []string{"node", "state", "totalmem", "cpus", "feature", "weight"}
 	labelsbyte := []string{"state", "feature"}
+	node := []string{"node"}
 	return &NodesInfoCollector{
+		legacy:      *nodesInfoLegacy,
+		info:        prometheus.NewDesc("slurm_node_info", "descriptive labels of a node, always 1", []string{"node", "features", "partitions", "arch", "os"}, nil),
+		memoryTotal: prometheus.NewDesc("slurm_node_memory_total_bytes", "configured memory of a node", node, nil),
+		memoryFree:  prometheus.NewDesc("slurm_node_memory_free_bytes", "free memory of a node as reported by the OS", node, nil),
+		memoryAlloc: prometheus.NewDesc("slurm_node_memory_alloc_bytes", "memory of a node allocated to jobs", node, nil),
+		cpusTotal:   prometheus.NewDesc("slurm_node_cpus_total", "CPUs of a node", node, nil),
+		cpusAlloc:   prometheus.NewDesc("slurm_node_cpus_alloc", "CPUs of a node allocated to jobs", node, nil),
+		cpusEfctv:   prometheus.NewDesc("slurm_node_cpus_effective", "CPUs of a node usable by jobs, without the CPUs reserved for the system", node, nil),
+		tresTotal:   prometheus.NewDesc("slurm_node_tres_total", "configured trackable resources of a node, memory in bytes", []string{"node", "tres"}, nil),
+		tresAlloc:   prometheus.NewDesc("slurm_node_tres_alloc", "trackable resources of a node allocated to jobs, memory in bytes", []string{"node", "tres"}, nil),
+		cpuLoad:     prometheus.NewDesc("slurm_node_cpu_load", "CPU load of a node", node, nil),
+		weight:      prometheus.NewDesc("slurm_node_weight", "scheduling weight of a node", node, nil),
+
 		freemem:  prometheus.NewDesc("slurm_node_freemem", "free node memory (MB)", labels, nil),
 		allocmem: prometheus.NewDesc("slurm_node_allocmem", "allocated node memory (MB)", labels, nil),
 		cpuload:  prometheus.NewDesc("slurm_node_cpuload", "node cpu load", labels, nil),
 		bytes:    prometheus.NewDesc("slurm_nodes_bytes", "total size of allocated/requested memory", labelsbyte, nil),
 		gpus:     prometheus.NewDesc("slurm_nodes_gpus", "total size of allocated/requested memory", labelsbyte, nil),
+		stateInfo: prometheus.NewDesc("slurm_node_state_info", "base state, state flags and reason of a node, always 1",
+			[]string{"node", "state", "flags", "reason", "reason_user"}, nil),
+		stateSince: prometheus.NewDesc("slurm_node_state_since_seconds", "Unix time the reason of a node was set",
+			[]string{"node"}, nil),
 	}
 }
 
 //NodesInfoCollector function
 type NodesInfoCollector struct {
+	legacy      bool // export freemem, allocmem and cpuload
+	info        *prometheus.Desc
+	memoryTotal *prometheus.Desc
+	memoryFree  *prometheus.Desc
+	memoryAlloc *prometheus.Desc
+	cpusTotal   *prometheus.Desc
+	cpusAlloc   *prometheus.Desc
+	cpusEfctv   *prometheus.Desc
+	tresTotal   *prometheus.Desc
+	tresAlloc   *prometheus.Desc
+	cpuLoad     *prometheus.Desc
+	weight      *prometheus.Desc
+
 	freemem  *prometheus.Desc
 	allocmem *prometheus.Desc
 	cpuload  *prometheus.Desc
 	bytes    *prometheus.Desc
 	gpus     *prometheus.Desc
+
+	stateInfo  *prometheus.Desc
+	stateSince *prometheus.Desc
 }
 
 //Describe Send all metric descriptions
 func (nic *NodesInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nic.info
+	ch <- nic.memoryTotal
+	ch <- nic.memoryFree
+	ch <- nic.memoryAlloc
+	ch <- nic.cpusTotal
+	ch <- nic.cpusAlloc
+	ch <- nic.cpusEfctv
+	ch <- nic.tresTotal
+	ch <- nic.tresAlloc
+	ch <- nic.cpuLoad
+	ch <- nic.weight
 	ch <- nic.freemem
 	ch <- nic.allocmem
 	ch <- nic.cpuload
 	ch <- nic.bytes
 	ch <- nic.gpus
+	ch <- nic.stateInfo
+	ch <- nic.stateSince
 }
 
-//Collect function
-func (nic *NodesInfoCollector) Collect(ch chan<- prometheus.Metric) {
-	pm := NodesInfoGetMetrics()
-	for p := range pm {
-		if pm[p].allocmem >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.allocmem, prometheus.GaugeValue,
-				pm[p].allocmem, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+//Update function
+func (nic *NodesInfoCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	nodes, err := scrape.Nodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(nic.info, prometheus.GaugeValue, 1,
+			node.Name, node.Features, strings.Join(node.Partitions, ","), node.Arch, node.OS)
+		ch <- prometheus.MustNewConstMetric(nic.memoryTotal, prometheus.GaugeValue, node.RealMemory*1024*1024, node.Name)
+		if node.HasFreeMemory {
+			ch <- prometheus.MustNewConstMetric(nic.memoryFree, prometheus.GaugeValue, node.FreeMemory*1024*1024, node.Name)
 		}
-		if pm[p].freemem >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.freemem, prometheus.GaugeValue,
-				pm[p].freemem, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+		ch <- prometheus.MustNewConstMetric(nic.memoryAlloc, prometheus.GaugeValue, node.AllocMemory*1024*1024, node.Name)
+		ch <- prometheus.MustNewConstMetric(nic.cpusTotal, prometheus.GaugeValue, node.CPUTotal, node.Name)
+		ch <- prometheus.MustNewConstMetric(nic.cpusAlloc, prometheus.GaugeValue, node.CPUAlloc, node.Name)
+		if node.CPUEffective > 0 {
+			ch <- prometheus.MustNewConstMetric(nic.cpusEfctv, prometheus.GaugeValue, node.CPUEffective, node.Name)
 		}
-		if pm[p].cpuload >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.cpuload, prometheus.GaugeValue,
-				pm[p].cpuload, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+		for name, count := range parseNodeTRES(node.Name, "CfgTRES", node.CfgTRES) {
+			ch <- prometheus.MustNewConstMetric(nic.tresTotal, prometheus.GaugeValue, count, node.Name, name)
 		}
-
-	}
-	//sinfo -e -o%e,%f,alloc --state allocated
-	cmd := exec.Command("sinfo", "-N", "-h", "-e", "--state=allocated", "-OAllocMem:10:,FreeMem:10:,StateLong:10:,Features:10,:alloc")
-	data := ParseNodesDataMetrics(NodesDataInfoData(cmd))
-
-	for d := range data {
-		if data[d] >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.bytes, prometheus.GaugeValue,
-				data[d], d.state, d.feature)
+		for name, count := range parseNodeTRES(node.Name, "AllocTRES", node.AllocTRES) {
+			ch <- prometheus.MustNewConstMetric(nic.tresAlloc, prometheus.GaugeValue, count, node.Name, name)
 		}
-	}
-	cmd = exec.Command("sinfo", "-N", "-h", "-e", "--state=idle", "-OAllocMem:10:,FreeMem:10:,StateLong:10:,Features:10,:free")
-	data = ParseNodesDataMetrics(NodesDataInfoData(cmd))
-	for d := range data {
-		if data[d] >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.bytes, prometheus.GaugeValue,
-				data[d], d.state, d.feature)
+		if node.HasCPULoad {
+			ch <- prometheus.MustNewConstMetric(nic.cpuLoad, prometheus.GaugeValue, node.CPULoad, node.Name)
 		}
-	}
-	cmd = exec.Command("sinfo", "-N", "-h", "-e", "--state=drained", "-OAllocMem:10:,FreeMem:10:,StateLong:10:,Features:10,:drained")
-	data = ParseNodesDataMetrics(NodesDataInfoData(cmd))
-	for d := range data {
-		if data[d] >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.bytes, prometheus.GaugeValue,
-				data[d], d.state, d.feature)
+		if node.Weight != "" {
+			ch <- prometheus.MustNewConstMetric(nic.weight, prometheus.GaugeValue,
+				parseFloat("ParseNodes", node.Weight), node.Name)
 		}
 	}
-	cmd = exec.Command("sinfo", "-N", "-h", "-e", "--state=maint", "-OAllocMem:10:,FreeMem:10:,StateLong:10:,Features:10,:maint")
-	data = ParseNodesDataMetrics(NodesDataInfoData(cmd))
-	for d := range data {
-		if data[d] >= 0 {
-			ch <- prometheus.MustNewConstMetric(nic.bytes, prometheus.GaugeValue,
-				data[d], d.state, d.feature)
+	if nic.legacy {
+		pm := ParseNodesInfoMetrics(nodes)
+		for p := range pm {
+			if pm[p].allocmem >= 0 {
+				ch <- prometheus.MustNewConstMetric(nic.allocmem, prometheus.GaugeValue,
+					pm[p].allocmem, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+			}
+			if pm[p].freemem >= 0 {
+				ch <- prometheus.MustNewConstMetric(nic.freemem, prometheus.GaugeValue,
+					pm[p].freemem, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+			}
+			if pm[p].cpuload >= 0 {
+				ch <- prometheus.MustNewConstMetric(nic.cpuload, prometheus.GaugeValue,
+					pm[p].cpuload, p, pm[p].state, pm[p].totalmem, pm[p].cpus, pm[p].feature, pm[p].weight)
+			}
+
 		}
 	}
-
-	cmd = exec.Command("sinfo", "-N", "-h", "-e", "--state=completing", "-OAllocMem:10:,FreeMem:10:,StateLong:10:,Features:10,:completing")
-	data = ParseNodesDataMetrics(NodesDataInfoData(cmd))
+	data := ParseNodesDataMetrics(nodes)
 	for d := range data {
 		if data[d] >= 0 {
 			ch <- prometheus.MustNewConstMetric(nic.bytes, prometheus.GaugeValue,
 				data[d], d.state, d.feature)
 		}
 	}
-	cmd = exec.Command("sinfo", "-N", "-h", "-e", "-OGres:10-,GresUsed:10-,StateLong:10-,Features:10")
-	data = ParseNodesGPUMetrics(NodesDataInfoData(cmd))
-	for d := range data {
-		if data[d] >= 0 {
+	gpus := ParseNodesGPUMetrics(nodes)
+	for d := range gpus {
+		if gpus[d] >= 0 {
 			ch <- prometheus.MustNewConstMetric(nic.gpus, prometheus.GaugeValue,
-				data[d], d.state, d.feature)
+				gpus[d], d.state, d.feature)
+		}
+	}
+	for _, node := range nodes {
+		ch <- prometheus.MustNewConstMetric(nic.stateInfo, prometheus.GaugeValue, 1,
+			node.Name, node.State, joinFlags(node.Flags), node.Reason, node.ReasonUser)
+		if !node.ReasonTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(nic.stateSince, prometheus.GaugeValue,
+				float64(node.ReasonTime.Unix()), node.Name)
 		}
 	}
+	return nil
 }
@@ -2,45 +2,191 @@ package main
 
 import (
 	"io/ioutil"
-	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestParseNodesInfoMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/nodeinfo.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
-	}
-	data, err := ioutil.ReadAll(file)
-	//t.Error(data)
-	metrics := ParseNodesInfoMetrics(data)
+	metrics := ParseNodesInfoMetrics(readNodes(t))
 	for k, v := range metrics {
-		//t.Error(k, v)
 		t.Log(k, v)
 	}
-	//t.Logf("%+v", ParseNodesInfoMetrics(data))
-
+	if len(metrics) != 39 {
+		t.Fatalf("Expected 39 nodes, got %d", len(metrics))
+	}
+	node := metrics["milton-gpu-001"]
+	if node.state != "mixed" || node.totalmem != "105251" || node.cpus != "48" ||
+		node.cpuload != 4.23 || node.weight != "1000" {
+		t.Errorf("Unexpected node %+v", node)
+	}
 }
 
 func TestParseNodesDataMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/nodeinfo2.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
+	metrics := ParseNodesDataMetrics(readNodes(t))
+	for k, v := range metrics {
+		t.Log(k, v)
 	}
-	data, err := ioutil.ReadAll(file)
-	//t.Error(data)
-	metrics := ParseNodesDataMetrics(data)
-	//.Error(metrics)
+	if metrics[MetricKey{"drained", ""}] != 108394 {
+		t.Errorf("Expected the free memory of the drained node, got %v", metrics[MetricKey{"drained", ""}])
+	}
+}
+
+func TestParseNodesGPUMetrics(t *testing.T) {
+	metrics := ParseNodesGPUMetrics(readNodes(t))
 	for k, v := range metrics {
-		t.Error(k, v)
 		t.Log(k, v)
 	}
-	//t.Logf("%+v", ParseNodesInfoMetrics(data))
+	if metrics[MetricKey{"alloc", ""}] != 4 {
+		t.Errorf("Expected 4 allocated GPUs, got %v", metrics[MetricKey{"alloc", ""}])
+	}
+}
+
+// Metrics of the nodesinfo collector by name and node, followed by the
+// resource for TRES metrics
+func gatherNodesInfo(t *testing.T) map[string]map[string]*dto.Metric {
+	useRunner(t, fakeRunner{"scontrol": "test_data/scontrol.txt"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector([]string{"nodesinfo"}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]map[string]*dto.Metric{}
+	for _, mf := range families {
+		metrics[mf.GetName()] = map[string]*dto.Metric{}
+		for _, m := range mf.GetMetric() {
+			labels := metricLabels(m)
+			key := labels["node"]
+			if tres, ok := labels["tres"]; ok {
+				key += " " + tres
+			}
+			metrics[mf.GetName()][key] = m
+		}
+	}
+	return metrics
+}
+
+func metricLabels(m *dto.Metric) map[string]string {
+	labels := map[string]string{}
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return labels
+}
+
+func TestNodeStateInfo(t *testing.T) {
+	metrics := gatherNodesInfo(t)
+	info := metrics["slurm_node_state_info"]
+	if len(info) != 39 {
+		t.Errorf("Expected the state of 39 nodes, got %d", len(info))
+	}
+	expected := map[string]string{"node": "milton-gpu-002", "state": "idle", "flags": "drain+not_responding",
+		"reason": "Kill task failed", "reason_user": "root"}
+	if labels := metricLabels(info["milton-gpu-002"]); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected %v, got %v", expected, labels)
+	}
+	since := metrics["slurm_node_state_since_seconds"]
+	if len(since) != 3 || since["milton-gpu-002"].GetGauge().GetValue() != float64(time.Date(2020, 11, 2, 9, 14, 27, 0, time.Local).Unix()) {
+		t.Errorf("Unexpected reason times %v", since)
+	}
+}
 
+func TestNodeStateInfoInvalidUTF8(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scontrol.txt")
+	ioutil.WriteFile(file, []byte("NodeName=milton-sml-001 State=IDLE+DRAIN OS=Linux\xff AvailableFeatures=caf\xe9 "+
+		"Reason=caf\xe9 broken [r\xf6ot@2020-11-02T09:14:27]\n"), 0644)
+	useRunner(t, fakeRunner{"scontrol": file})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector([]string{"nodesinfo"}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "slurm_node_state_info" {
+			continue
+		}
+		labels := metricLabels(mf.GetMetric()[0])
+		if labels["reason"] != "caf\uFFFD broken" || labels["reason_user"] != "r\uFFFDot" {
+			t.Errorf("Expected the invalid UTF-8 to be replaced, got %v", labels)
+		}
+		return
+	}
+	t.Errorf("Expected the state of the node")
 }
 
-/*func TestNodesInfoGetMetrics(t *testing.T) {
-	t.Logf("%+v", NodesInfoGetMetrics())
-}*/
+// A node which is down reports neither its free memory nor its load
+func TestNodeGaugesUnknown(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scontrol.txt")
+	ioutil.WriteFile(file, []byte("NodeName=milton-sml-001 CPUTot=56 CPULoad=N/A RealMemory=1000 FreeMem=N/A State=DOWN*\n"), 0644)
+	useRunner(t, fakeRunner{"scontrol": file})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector([]string{"nodesinfo"}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, mf := range families {
+		names[mf.GetName()] = true
+	}
+	if !names["slurm_node_memory_total_bytes"] || names["slurm_node_memory_free_bytes"] || names["slurm_node_cpu_load"] {
+		t.Errorf("Expected no free memory and CPU load of the down node, got %v", names)
+	}
+}
+
+func TestNodeGauges(t *testing.T) {
+	metrics := gatherNodesInfo(t)
+	for name, value := range map[string]float64{
+		"slurm_node_memory_total_bytes": 105251 * 1024 * 1024,
+		"slurm_node_memory_free_bytes":  96972 * 1024 * 1024,
+		"slurm_node_memory_alloc_bytes": 41984 * 1024 * 1024,
+		"slurm_node_cpus_total":         48,
+		"slurm_node_cpus_alloc":         41,
+		"slurm_node_cpu_load":           4.23,
+		"slurm_node_weight":             1000,
+		"slurm_node_info":               1,
+	} {
+		if v := metrics[name]["milton-gpu-001"].GetGauge().GetValue(); v != value {
+			t.Errorf("Expected %s %v, got %v", name, value, v)
+		}
+	}
+	expected := map[string]string{"node": "milton-gpu-001", "features": "", "partitions": "gpuq",
+		"arch": "x86_64", "os": "Linux 3.10.0-1160.25.1.el7.x86_64 #1 SMP Wed Apr 28 21:49:45 UTC 2021"}
+	if labels := metricLabels(metrics["slurm_node_info"]["milton-gpu-001"]); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected %v, got %v", expected, labels)
+	}
+	for key, value := range map[string]float64{
+		"milton-gpu-001 cpu":      48,
+		"milton-gpu-001 mem":      105251 * 1024 * 1024,
+		"milton-gpu-001 gres/gpu": 4,
+	} {
+		if v := metrics["slurm_node_tres_total"][key].GetGauge().GetValue(); v != value {
+			t.Errorf("Expected configured TRES %s %v, got %v", key, value, v)
+		}
+	}
+	if v := metrics["slurm_node_tres_alloc"]["milton-gpu-001 gres/gpu"].GetGauge().GetValue(); v != 1 {
+		t.Errorf("Expected 1 allocated GPU, got %v", v)
+	}
+	if _, ok := metrics["slurm_node_tres_alloc"]["milton-gpu-002 cpu"]; ok {
+		t.Errorf("Expected no allocated TRES on an idle node")
+	}
+	if _, ok := metrics["slurm_node_cpus_effective"]; ok {
+		t.Errorf("Expected no effective CPUs before Slurm 22.05")
+	}
+	if _, ok := metrics["slurm_node_freemem"]; ok {
+		t.Errorf("Expected no legacy metrics by default")
+	}
+
+	*nodesInfoLegacy = true
+	defer func() { *nodesInfoLegacy = false }()
+	metrics = gatherNodesInfo(t)
+	if v := metrics["slurm_node_freemem"]["milton-gpu-001"].GetGauge().GetValue(); v != 96972 {
+		t.Errorf("Expected the legacy free memory in MB, got %v", v)
+	}
+}
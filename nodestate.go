@@ -0,0 +1,147 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+ * Slurm prints the state of a node as a base state, followed by flags.
+ * scontrol joins them with a plus sign, e.g. IDLE+CLOUD+POWERED_DOWN,
+ * while sinfo folds common combinations into one name, e.g. drained for
+ * an idle node with the drain flag, and marks others with a suffix, e.g.
+ * idle~ for a powered down node. Both forms are decoded into the same
+ * base state and flags.
+ */
+
+// Base states of a node, the short names are printed by sinfo %t
+var nodeBaseStates = map[string]string{
+	"allocated": "allocated",
+	"alloc":     "allocated",
+	"down":      "down",
+	"error":     "error",
+	"err":       "error",
+	"future":    "future",
+	"futr":      "future",
+	"idle":      "idle",
+	"mixed":     "mixed",
+	"mix":       "mixed",
+	"unknown":   "unknown",
+	"unk":       "unknown",
+}
+
+// sinfo names of a base state combined with a flag
+var nodeCombinedStates = map[string][2]string{
+	"completing":    {"allocated", "completing"},
+	"comp":          {"allocated", "completing"},
+	"drained":       {"idle", "drain"},
+	"drain":         {"idle", "drain"},
+	"draining":      {"allocated", "drain"},
+	"drng":          {"allocated", "drain"},
+	"fail":          {"idle", "fail"},
+	"failing":       {"allocated", "fail"},
+	"failg":         {"allocated", "fail"},
+	"maint":         {"idle", "maint"},
+	"reserved":      {"idle", "reserved"},
+	"resv":          {"idle", "reserved"},
+	"planned":       {"idle", "planned"},
+	"plnd":          {"idle", "planned"},
+	"inval":         {"unknown", "invalid_reg"},
+	"boot":          {"down", "reboot_issued"},
+	"rebooting":     {"down", "reboot_issued"},
+	"powered_down":  {"idle", "powered_down"},
+	"pow_dn":        {"idle", "powered_down"},
+	"powering_up":   {"idle", "powering_up"},
+	"pow_up":        {"idle", "powering_up"},
+	"powering_down": {"idle", "powering_down"},
+	"power_down":    {"idle", "power_down"},
+}
+
+// Flags printed by sinfo as a suffix of the state
+var nodeStateSuffixes = map[byte]string{
+	'*': "not_responding",
+	'~': "powered_down",
+	'#': "powering_up",
+	'!': "power_down",
+	'%': "powering_down",
+	'$': "maint",
+	'@': "reboot_requested",
+	'^': "reboot_issued",
+	'-': "planned",
+}
+
+// Flags printed under different names by the Slurm versions
+var nodeFlagAliases = map[string]string{
+	"maintenance": "maint",
+	"no_respond":  "not_responding",
+	"drng":        "drain",
+}
+
+// Split a node state like IDLE*+DRAIN or idle~ into the base state and
+// its flags, all in lower case. A state which is not known is kept as
+// base state.
+func parseNodeState(s string) (string, []string) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "+")
+	token := parts[0]
+	flags := []string{}
+	add := func(flag string) {
+		if alias, ok := nodeFlagAliases[flag]; ok {
+			flag = alias
+		}
+		for _, f := range flags {
+			if f == flag {
+				return
+			}
+		}
+		flags = append(flags, flag)
+	}
+	// suffixes are collected from the end, e.g. idle*~
+	suffixes := []string{}
+	for len(token) > 1 {
+		flag, ok := nodeStateSuffixes[token[len(token)-1]]
+		if !ok {
+			break
+		}
+		suffixes = append([]string{flag}, suffixes...)
+		token = token[:len(token)-1]
+	}
+	base := token
+	if b, ok := nodeBaseStates[token]; ok {
+		base = b
+	} else if c, ok := nodeCombinedStates[token]; ok {
+		base = c[0]
+		add(c[1])
+	}
+	for _, flag := range parts[1:] {
+		if flag != "" {
+			add(flag)
+		}
+	}
+	for _, flag := range suffixes {
+		add(flag)
+	}
+	return base, flags
+}
+
+// Flags of a node in alphabetical order joined by a plus sign, empty
+// without flags
+func joinFlags(flags []string) string {
+	sorted := append([]string{}, flags...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "+")
+}
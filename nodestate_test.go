@@ -0,0 +1,72 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNodeState(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		base  string
+		flags []string
+	}{
+		// scontrol
+		{"MIXED", "mixed", []string{}},
+		{"IDLE*+DRAIN", "idle", []string{"drain", "not_responding"}},
+		{"IDLE+CLOUD+POWERED_DOWN", "idle", []string{"cloud", "powered_down"}},
+		{"MIXED+PLANNED", "mixed", []string{"planned"}},
+		{"IDLE+MAINTENANCE", "idle", []string{"maint"}},
+		{"DOWN+NOT_RESPONDING", "down", []string{"not_responding"}},
+		{"FUTURE", "future", []string{}},
+		{"UNKNOWN*", "unknown", []string{"not_responding"}},
+		// sinfo %T and %t
+		{"idle~", "idle", []string{"powered_down"}},
+		{"idle#", "idle", []string{"powering_up"}},
+		{"mix@", "mixed", []string{"reboot_requested"}},
+		{"drain$", "idle", []string{"drain", "maint"}},
+		{"drained*", "idle", []string{"drain", "not_responding"}},
+		{"draining", "allocated", []string{"drain"}},
+		{"alloc%", "allocated", []string{"powering_down"}},
+		{"idle!", "idle", []string{"power_down"}},
+		{"idle-", "idle", []string{"planned"}},
+		{"planned", "idle", []string{"planned"}},
+		{"inval", "unknown", []string{"invalid_reg"}},
+		{"powered_down", "idle", []string{"powered_down"}},
+		{"powering_up", "idle", []string{"powering_up"}},
+		{"boot", "down", []string{"reboot_issued"}},
+		{"rebooting", "down", []string{"reboot_issued"}},
+		{"down*~", "down", []string{"not_responding", "powered_down"}},
+		// unknown states are kept
+		{"blocked", "blocked", []string{}},
+	} {
+		base, flags := parseNodeState(tc.input)
+		if base != tc.base || !reflect.DeepEqual(flags, tc.flags) {
+			t.Errorf("%s: expected %s %v, got %s %v", tc.input, tc.base, tc.flags, base, flags)
+		}
+	}
+}
+
+func TestJoinFlags(t *testing.T) {
+	if s := joinFlags([]string{"not_responding", "drain"}); s != "drain+not_responding" {
+		t.Errorf("Expected the flags in alphabetical order, got %s", s)
+	}
+	if s := joinFlags(nil); s != "" {
+		t.Errorf("Expected no flags, got %s", s)
+	}
+}
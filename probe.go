@@ -0,0 +1,104 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+ * The /probe endpoint collects one cluster with one set of collectors per
+ * request, like the blackbox exporter. Prometheus selects the cluster and
+ * the collectors with relabeling, so cheap and expensive collectors can be
+ * scraped on different intervals. Every probe uses a fresh registry.
+ */
+
+// ProbeHandler serves /probe?cluster=<name>&module=<module>
+type ProbeHandler struct {
+	cfg   *Config
+	names []string // collectors probed without module
+}
+
+func NewProbeHandler(cfg *Config, names []string) *ProbeHandler {
+	return &ProbeHandler{cfg: cfg, names: names}
+}
+
+// Collectors of a module, either a module of the configuration file or a
+// comma separated list of collectors. All enabled collectors are used
+// without module.
+func (h *ProbeHandler) collectors(module string) ([]string, error) {
+	if module == "" {
+		return h.names, nil
+	}
+	if names, ok := h.cfg.Modules[module]; ok {
+		return names, nil
+	}
+	names := strings.Split(module, ",")
+	for _, name := range names {
+		if _, ok := collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown module or collector %q", name)
+		}
+	}
+	return names, nil
+}
+
+// Cluster of a probe, nil for the local cluster. Only the clusters of the
+// configuration can be probed.
+func (h *ProbeHandler) cluster(name string) (*Cluster, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for i := range h.cfg.Clusters {
+		if h.cfg.Clusters[i].Name == name {
+			return &h.cfg.Clusters[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cluster %q", name)
+}
+
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	names, err := h.collectors(r.URL.Query().Get("module"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cluster, err := h.cluster(r.URL.Query().Get("cluster"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := scrapeContext(r, *timeoutOffset)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	sc := NewSlurmCollector(names)
+	// a probe of a single collector says nothing about the readiness
+	sc.ctx = withoutHealth(ctx)
+	sc.cluster = cluster
+	if err := prometheus.WrapRegistererWith(h.cfg.ExtraLabels, registry).Register(sc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var gatherer prometheus.Gatherer = registry
+	if len(h.cfg.LabelFilters) > 0 || len(h.cfg.CardinalityLimits) > 0 {
+		gatherer = NewFilterGatherer(registry, h.cfg.LabelFilters, h.cfg.CardinalityLimits)
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
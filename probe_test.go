@@ -0,0 +1,67 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func probe(t *testing.T, cfg *Config, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	NewProbeHandler(cfg, enabledCollectors()).ServeHTTP(w, httptest.NewRequest("GET", "/probe?"+query, nil))
+	return w
+}
+
+func TestProbe(t *testing.T) {
+	useRunner(t, clusterRunner{})
+	cfg, err := ParseConfig([]byte(`
+clusters:
+  - name: a
+modules:
+  cheap: [scheduler]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := probe(t, cfg, "cluster=a&module=cheap")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "slurm_scheduler_threads 3") {
+		t.Errorf("Expected the scheduler metrics, got %s", body)
+	}
+	if strings.Contains(body, "slurm_queue_pending") {
+		t.Errorf("Expected only the collectors of the module")
+	}
+	// collectors can be listed instead of a module
+	w = probe(t, cfg, "cluster=a&module=queue,scheduler")
+	if !strings.Contains(w.Body.String(), "slurm_queue_pending") {
+		t.Errorf("Expected the queue metrics, got %s", w.Body)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	cfg := &Config{Clusters: []Cluster{{Name: "a"}}}
+	for _, query := range []string{"cluster=b", "cluster=a&module=expensive"} {
+		if w := probe(t, cfg, query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
@@ -17,12 +17,12 @@ package main
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"io/ioutil"
-	"log"
-	"os/exec"
-	"strings"
 )
 
+func init() {
+	registerCollector("queue", true, func() Collector { return NewQueueCollector() })
+}
+
 type QueueMetrics struct {
 	pending     float64
 	pending_dep float64
@@ -38,67 +38,41 @@ type QueueMetrics struct {
 	node_fail   float64
 }
 
-// Returns the scheduler metrics
-func QueueGetMetrics() *QueueMetrics {
-	return ParseQueueMetrics(QueueData())
-}
-
-func ParseQueueMetrics(input []byte) *QueueMetrics {
+// Count the jobs in each state
+func ParseQueueMetrics(jobs []Job) *QueueMetrics {
 	var qm QueueMetrics
-	lines := strings.Split(string(input), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, ",") {
-			splitted := strings.Split(line, ",")
-			state := splitted[1]
-			switch state {
-			case "PENDING":
-				qm.pending++
-				if len(splitted) > 2 && splitted[2] == "Dependency" {
-					qm.pending_dep++
-				}
-			case "RUNNING":
-				qm.running++
-			case "SUSPENDED":
-				qm.suspended++
-			case "CANCELLED":
-				qm.cancelled++
-			case "COMPLETING":
-				qm.completing++
-			case "COMPLETED":
-				qm.completed++
-			case "CONFIGURING":
-				qm.configuring++
-			case "FAILED":
-				qm.failed++
-			case "TIMEOUT":
-				qm.timeout++
-			case "PREEMPTED":
-				qm.preempted++
-			case "NODE_FAIL":
-				qm.node_fail++
+	for _, job := range jobs {
+		switch job.State {
+		case "PENDING":
+			qm.pending++
+			if job.Reason == "Dependency" {
+				qm.pending_dep++
 			}
+		case "RUNNING":
+			qm.running++
+		case "SUSPENDED":
+			qm.suspended++
+		case "CANCELLED":
+			qm.cancelled++
+		case "COMPLETING":
+			qm.completing++
+		case "COMPLETED":
+			qm.completed++
+		case "CONFIGURING":
+			qm.configuring++
+		case "FAILED":
+			qm.failed++
+		case "TIMEOUT":
+			qm.timeout++
+		case "PREEMPTED":
+			qm.preempted++
+		case "NODE_FAIL":
+			qm.node_fail++
 		}
 	}
 	return &qm
 }
 
-// Execute the squeue command and return its output
-func QueueData() []byte {
-	cmd := exec.Command("squeue", "-a", "-r", "-h", "-o %A,%T,%r", "--states=all")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
-}
-
 /*
  * Implement the Prometheus Collector interface and feed the
  * Slurm queue metrics into it.
@@ -152,8 +126,12 @@ func (qc *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- qc.node_fail
 }
 
-func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) {
-	qm := QueueGetMetrics()
+func (qc *QueueCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	jobs, err := scrape.Jobs()
+	if err != nil {
+		return err
+	}
+	qm := ParseQueueMetrics(jobs)
 	ch <- prometheus.MustNewConstMetric(qc.pending, prometheus.GaugeValue, qm.pending)
 	ch <- prometheus.MustNewConstMetric(qc.pending_dep, prometheus.GaugeValue, qm.pending_dep)
 	ch <- prometheus.MustNewConstMetric(qc.running, prometheus.GaugeValue, qm.running)
@@ -166,4 +144,5 @@ func (qc *QueueCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(qc.timeout, prometheus.GaugeValue, qm.timeout)
 	ch <- prometheus.MustNewConstMetric(qc.preempted, prometheus.GaugeValue, qm.preempted)
 	ch <- prometheus.MustNewConstMetric(qc.node_fail, prometheus.GaugeValue, qm.node_fail)
+	return nil
 }
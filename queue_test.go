@@ -16,21 +16,13 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"os"
 	"testing"
 )
 
 func TestParseQueueMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/squeue.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
+	qm := ParseQueueMetrics(readJobs(t))
+	t.Logf("%+v", qm)
+	if qm.pending != 45 || qm.pending_dep != 2 || qm.running != 55 || qm.completing != 2 {
+		t.Errorf("Unexpected queue metrics %+v", qm)
 	}
-	data, err := ioutil.ReadAll(file)
-	t.Logf("%+v", ParseQueueMetrics(data))
-}
-
-func TestQueueGetMetrics(t *testing.T) {
-	t.Logf("%+v", QueueGetMetrics())
 }
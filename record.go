@@ -0,0 +1,255 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ * In record mode the enabled collectors are run once and the output of
+ * every Slurm command is written into a bundle directory, together with
+ * a manifest describing how each command was executed. The bundle can be
+ * attached to a bug report and served again with --replay-dir.
+ */
+
+// CommandRecord describes a single command executed in record mode
+type CommandRecord struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	ExitCode int      `json:"exit_code"`
+	Duration float64  `json:"duration_seconds"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	output []byte
+	errors []byte
+}
+
+// Manifest of a capture bundle
+type Manifest struct {
+	Created      time.Time       `json:"created"`
+	SlurmVersion string          `json:"slurm_version"`
+	Output       string          `json:"output"` // json or text, read by --replay-dir
+	Collectors   []string        `json:"collectors"`
+	Scrubbed     bool            `json:"scrubbed"`
+	Commands     []CommandRecord `json:"commands"`
+}
+
+// RecordingRunner keeps the output of every command run by another runner
+type RecordingRunner struct {
+	runner CommandRunner
+
+	mu      sync.Mutex
+	records []CommandRecord
+}
+
+func NewRecordingRunner(runner CommandRunner) *RecordingRunner {
+	return &RecordingRunner{runner: runner}
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	start := time.Now()
+	out, err := r.runner.Run(ctx, name, args...)
+	record := CommandRecord{
+		Command:  name,
+		Args:     args,
+		Duration: time.Since(start).Seconds(),
+		output:   out,
+	}
+	if err != nil {
+		record.ExitCode = -1
+		record.Error = err.Error()
+		if cerr, ok := err.(*CommandError); ok {
+			record.ExitCode = cerr.ExitCode
+			record.errors = []byte(cerr.Stderr)
+		}
+	}
+	r.mu.Lock()
+	r.records = append(r.records, record)
+	r.mu.Unlock()
+	return out, err
+}
+
+// Record runs the named collectors of the clusters once through the
+// current runner and writes the command output into a new bundle below
+// dir, the path of the bundle is returned
+func Record(dir string, names []string, clusters []Cluster, scrub bool) (string, error) {
+	recorder := NewRecordingRunner(runner)
+	old := runner
+	setRunner(recorder)
+	defer setRunner(old)
+
+	registry := prometheus.NewRegistry()
+	if err := registerCollectors(collectContext, registry, names, clusters); err != nil {
+		return "", err
+	}
+	// failing collectors are part of the capture, their errors are
+	// recorded in the manifest
+	registry.Gather()
+
+	manifest := Manifest{
+		Created:    time.Now(),
+		Collectors: names,
+		Scrubbed:   scrub,
+		Output:     "text",
+	}
+	if s, ok := source.(*CommandSource); ok && s.JSON() {
+		manifest.Output = "json"
+	}
+	if out, err := old.Run(context.Background(), "sinfo", "--version"); err == nil {
+		manifest.SlurmVersion = strings.TrimSpace(string(out))
+	}
+
+	bundle := filepath.Join(dir, "slurm-capture-"+manifest.Created.Format("20060102T150405"))
+	if err := os.MkdirAll(bundle, 0755); err != nil {
+		return "", err
+	}
+	var scrubber *Scrubber
+	if scrub {
+		scrubber = NewScrubber(recorder.records)
+	}
+	files := map[string]int{}
+	for _, record := range recorder.records {
+		// the first output of a command is read by --replay-dir, further
+		// executions are numbered
+		base := record.Command
+		if files[base]++; files[base] > 1 {
+			base = fmt.Sprintf("%s-%d", record.Command, files[record.Command])
+		}
+		if scrubber != nil {
+			record.Args = scrubber.Strings(record.Args)
+			record.Error = scrubber.String(record.Error)
+			record.output = scrubber.Bytes(record.output)
+			record.errors = scrubber.Bytes(record.errors)
+		}
+		record.Stdout = base + ".txt"
+		if err := ioutil.WriteFile(filepath.Join(bundle, record.Stdout), record.output, 0644); err != nil {
+			return "", err
+		}
+		if len(record.errors) > 0 {
+			record.Stderr = base + ".stderr"
+			if err := ioutil.WriteFile(filepath.Join(bundle, record.Stderr), record.errors, 0644); err != nil {
+				return "", err
+			}
+		}
+		manifest.Commands = append(manifest.Commands, record)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(bundle, "manifest.json"), data, 0644); err != nil {
+		return "", err
+	}
+	return bundle, nil
+}
+
+// Scrubber replaces user and account names with generic ones, the same
+// name is always replaced by the same replacement
+type Scrubber struct {
+	names   map[string]string
+	counts  map[string]int
+	pattern *regexp.Regexp
+}
+
+// NewScrubber collects the user and account names from the squeue,
+// scontrol and sinfo output of the records, in text or JSON format
+func NewScrubber(records []CommandRecord) *Scrubber {
+	s := &Scrubber{names: map[string]string{}, counts: map[string]int{}}
+	for _, record := range records {
+		jobs, nodes := parseRecord(record)
+		for _, job := range jobs {
+			s.add(job.User, "user")
+			s.add(job.Account, "account")
+		}
+		for _, node := range nodes {
+			s.add(node.ReasonUser, "user")
+		}
+	}
+	quoted := []string{}
+	for name := range s.names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	// longer names first, so a name is not replaced by a part of it
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	if len(quoted) > 0 {
+		s.pattern = regexp.MustCompile(`\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return s
+}
+
+// Returns the jobs and nodes listed in the output of a recorded command
+func parseRecord(record CommandRecord) ([]Job, []Node) {
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(record.output), []byte("{"))
+	switch {
+	case record.Command == "squeue" && isJSON:
+		jobs, _ := ParseJobsJSON(record.output)
+		return jobs, nil
+	case record.Command == "squeue":
+		return ParseJobs(record.output), nil
+	case record.Command == "sinfo" && isJSON:
+		nodes, _ := ParseNodesJSON(record.output)
+		return nil, nodes
+	case record.Command == "scontrol":
+		return nil, ParseNodes(record.output)
+	}
+	return nil, nil
+}
+
+// Adds a name with a generic replacement, e.g. user1
+func (s *Scrubber) add(name string, kind string) {
+	if _, ok := s.names[name]; ok || name == "" {
+		return
+	}
+	s.counts[kind]++
+	s.names[name] = fmt.Sprintf("%s%d", kind, s.counts[kind])
+}
+
+func (s *Scrubber) Bytes(b []byte) []byte {
+	if s.pattern == nil {
+		return b
+	}
+	return s.pattern.ReplaceAllFunc(b, func(name []byte) []byte {
+		return []byte(s.names[string(name)])
+	})
+}
+
+func (s *Scrubber) String(str string) string {
+	return string(s.Bytes([]byte(str)))
+}
+
+func (s *Scrubber) Strings(strs []string) []string {
+	scrubbed := make([]string, len(strs))
+	for i, str := range strs {
+		scrubbed[i] = s.String(str)
+	}
+	return scrubbed
+}
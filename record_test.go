@@ -0,0 +1,101 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	useRunner(t, fakeRunner{"squeue": "test_data/squeue.txt", "sdiag": "test_data/sdiag.txt"})
+	bundle, err := Record(t.TempDir(), []string{"queue", "scheduler", "nodes"}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(bundle, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Commands) != 3 || !manifest.Scrubbed {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+	for _, record := range manifest.Commands {
+		if record.Command == "scontrol" && (record.ExitCode != -1 || record.Error == "") {
+			t.Errorf("Expected the missing scontrol to be recorded as failed, got %+v", record)
+		}
+	}
+	squeue, err := ioutil.ReadFile(filepath.Join(bundle, "squeue.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(squeue), "bedo.j") || strings.Contains(string(squeue), "bioinf") {
+		t.Errorf("Expected user and account names to be scrubbed")
+	}
+	// the bundle can be replayed
+	jobs := ParseJobs(squeue)
+	if len(jobs) != 110 || jobs[0].User != "user1" || jobs[0].Account != "account1" {
+		t.Errorf("Unexpected scrubbed jobs %+v", jobs[0])
+	}
+	if _, err := NewReplayRunner(bundle).Run(context.Background(), "sdiag"); err != nil {
+		t.Errorf("Can not replay the bundle: %v", err)
+	}
+}
+
+func TestScrubberNodesAndJSON(t *testing.T) {
+	records := []CommandRecord{}
+	for _, file := range [][2]string{
+		{"scontrol", "test_data/scontrol.txt"},
+		{"squeue", "test_data/slurmrestd/jobs.json"},
+		{"sinfo", "test_data/slurmrestd/nodes.json"},
+	} {
+		data, err := ioutil.ReadFile(file[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, CommandRecord{Command: file[0], output: data})
+	}
+	scrubber := NewScrubber(records)
+	for _, record := range records {
+		out := string(scrubber.Bytes(record.output))
+		for _, name := range []string{"iskander.j", "bedo.j", "smith.a", "bioinf"} {
+			if strings.Contains(out, name) {
+				t.Errorf("Expected %q to be scrubbed from the %s output", name, record.Command)
+			}
+		}
+	}
+	nodes := ParseNodes(scrubber.Bytes(records[0].output))
+	if len(nodes) == 0 {
+		t.Fatalf("Expected the scrubbed scontrol output to be parsed")
+	}
+	jsonNodes, err := ParseNodesJSON(scrubber.Bytes(records[2].output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range append(nodes, jsonNodes...) {
+		if node.ReasonUser != "" && !strings.HasPrefix(node.ReasonUser, "user") {
+			t.Errorf("Expected the reason user of %s to be scrubbed, got %q", node.Name, node.ReasonUser)
+		}
+	}
+}
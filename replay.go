@@ -0,0 +1,64 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+/*
+ * In replay mode the Slurm commands are not executed, their output is
+ * read from files instead. The collectors and parsers are the same as in
+ * production, so captured output can be served by the real exporter on a
+ * host without Slurm.
+ */
+
+// ReplayRunner serves the output of each command from <dir>/<command>.txt,
+// e.g. squeue.txt or scontrol.txt, the arguments are ignored
+type ReplayRunner struct {
+	Dir string
+}
+
+// NewReplayRunner returns a runner reading the command output from dir
+func NewReplayRunner(dir string) *ReplayRunner {
+	return &ReplayRunner{Dir: dir}
+}
+
+func (r *ReplayRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := ioutil.ReadFile(filepath.Join(r.Dir, name+".txt"))
+	if err != nil {
+		return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: err}
+	}
+	return out, nil
+}
+
+// Returns true if the output in dir was recorded with --json. The format
+// is read from the manifest of a bundle, without one squeue.txt is
+// checked for a JSON document.
+func replayJSON(dir string) bool {
+	var manifest Manifest
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json")); err == nil {
+		if json.Unmarshal(data, &manifest) == nil && manifest.Output != "" {
+			return manifest.Output == "json"
+		}
+	}
+	out, err := ioutil.ReadFile(filepath.Join(dir, "squeue.txt"))
+	return err == nil && bytes.HasPrefix(bytes.TrimSpace(out), []byte("{"))
+}
@@ -0,0 +1,95 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReplayRunner(t *testing.T) {
+	r := NewReplayRunner("test_data")
+	out, err := r.Run(context.Background(), "sdiag")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(out) == 0 {
+		t.Errorf("Expected the content of test_data/sdiag.txt")
+	}
+	_, err = r.Run(context.Background(), "sacct")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+}
+
+// All collectors succeed on the captured output in test_data
+func TestReplayAllCollectors(t *testing.T) {
+	useRunner(t, NewReplayRunner("test_data"))
+	names := []string{}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector(names))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "slurm_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			if m.GetGauge().GetValue() != 1 {
+				t.Errorf("Collector %s failed", m.Label[0].GetValue())
+			}
+		}
+	}
+}
+
+// A bundle recorded with JSON output is replayed with JSON output
+func TestReplayJSONBundle(t *testing.T) {
+	useRunner(t, fakeRunner{
+		"squeue": "test_data/slurmrestd/jobs.json",
+		"sinfo":  "test_data/slurmrestd/nodes.json",
+		"sdiag":  "test_data/slurmrestd/diag.json",
+	})
+	useSource(t, NewCommandSource(true))
+	bundle, err := Record(t.TempDir(), []string{"queue", "scheduler", "nodesinfo"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replayJSON(bundle) {
+		t.Fatalf("Expected the bundle to be replayed with JSON output")
+	}
+	if replayJSON("test_data") {
+		t.Errorf("Expected the text output in test_data")
+	}
+	useRunner(t, NewReplayRunner(bundle))
+	useSource(t, NewCommandSource(replayJSON(bundle)))
+	jobs, err := source.Jobs(context.Background())
+	if err != nil || len(jobs) == 0 {
+		t.Errorf("Expected the jobs of the bundle, got %d: %v", len(jobs), err)
+	}
+	nodes, err := source.Nodes(context.Background())
+	if err != nil || len(nodes) != 4 {
+		t.Errorf("Expected 4 nodes of the bundle, got %d: %v", len(nodes), err)
+	}
+}
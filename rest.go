@@ -0,0 +1,339 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * The RESTSource requests the Slurm data from the JSON endpoints of
+ * slurmrestd, the exporter host needs neither the Slurm commands nor the
+ * munge key. Requests are authenticated with a JWT token.
+ */
+
+// RESTSource reads the Slurm data from slurmrestd
+type RESTSource struct {
+	URL       string // e.g. http://slurmrestd:6820
+	Version   string // version of the API, e.g. v0.0.37
+	User      string
+	Token     string
+	TokenFile string // read on every request, so the token can be rotated
+	Client    *http.Client
+}
+
+// NewRESTSource returns a source requesting the given API version from
+// url, requests are cancelled after timeout
+func NewRESTSource(url string, version string, timeout time.Duration) *RESTSource {
+	return &RESTSource{
+		URL:     strings.TrimSuffix(url, "/"),
+		Version: version,
+		Client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Error reported by slurmrestd in the body of a response
+type restError struct {
+	Error string `json:"error"`
+	Errno int    `json:"errno"`
+}
+
+// Request an endpoint of the API and decode the JSON response into v
+func (s *RESTSource) get(ctx context.Context, endpoint string, v interface{}) (err error) {
+	defer func() { recordHealth(ctx, "slurmrestd/"+endpoint, err) }()
+	url := fmt.Sprintf("%s/slurm/%s/%s", s.URL, s.Version, endpoint)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	token := s.Token
+	if s.TokenFile != "" {
+		data, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return fmt.Errorf("can not read the slurmrestd token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if s.User != "" {
+		req.Header.Set("X-SLURM-USER-NAME", s.User)
+	}
+	if token != "" {
+		req.Header.Set("X-SLURM-USER-TOKEN", token)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("GET %s: %v", url, err)
+	}
+	var errs struct {
+		Errors []restError `json:"errors"`
+	}
+	json.Unmarshal(body, &errs)
+	if len(errs.Errors) > 0 {
+		return fmt.Errorf("GET %s: %s (errno %d)", url, errs.Errors[0].Error, errs.Errors[0].Errno)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("GET %s: %v", url, err)
+	}
+	return nil
+}
+
+// Node as returned by the nodes endpoint
+type restNode struct {
+	Name           string   `json:"name"`
+	State          string   `json:"state"`
+	StateFlags     []string `json:"state_flags"`
+	Partitions     []string `json:"partitions"`
+	CPUs           float64  `json:"cpus"`
+	EffectiveCPUs  float64  `json:"effective_cpus"`
+	AllocCPUs      float64  `json:"alloc_cpus"`
+	CPULoad        float64  `json:"cpu_load"` // load times 100
+	RealMemory     float64  `json:"real_memory"`
+	AllocMemory    float64  `json:"alloc_memory"`
+	FreeMemory     float64  `json:"free_memory"`
+	Gres           string   `json:"gres"`
+	GresUsed       string   `json:"gres_used"`
+	TRES           string   `json:"tres"`
+	TRESUsed       string   `json:"tres_used"`
+	Features       string   `json:"features"`
+	ActiveFeatures string   `json:"active_features"`
+	Weight         float64  `json:"weight"`
+	Arch           string   `json:"architecture"`
+	OS             string   `json:"operating_system"`
+	Reason         string   `json:"reason"`
+	ReasonTime     int64    `json:"reason_changed_at"` // Unix time
+	ReasonUser     string   `json:"reason_set_by_user"`
+}
+
+// Job as returned by the jobs endpoint
+type restJob struct {
+	JobID         int64   `json:"job_id"`
+	UserName      string  `json:"user_name"`
+	Account       string  `json:"account"`
+	Partition     string  `json:"partition"`
+	JobState      string  `json:"job_state"`
+	CPUs          float64 `json:"cpus"`
+	MemoryPerNode float64 `json:"memory_per_node"`
+	MemoryPerCPU  float64 `json:"memory_per_cpu"`
+	StateReason   string  `json:"state_reason"`
+	// pending tasks of a job array, e.g. 1-10%2, listed once
+	ArrayTaskString string `json:"array_task_string"`
+}
+
+// Statistics as returned by the diag endpoint
+type restDiag struct {
+	ServerThreadCount      float64 `json:"server_thread_count"`
+	AgentQueueSize         float64 `json:"agent_queue_size"`
+	DBDAgentQueueSize      float64 `json:"dbd_agent_queue_size"`
+	ScheduleCycleLast      float64 `json:"schedule_cycle_last"`
+	ScheduleCycleMean      float64 `json:"schedule_cycle_mean"`
+	ScheduleCyclePerMinute float64 `json:"schedule_cycle_per_minute"`
+	BfCycleLast            float64 `json:"bf_cycle_last"`
+	BfCycleMean            float64 `json:"bf_cycle_mean"`
+	BfDepthMean            float64 `json:"bf_depth_mean"`
+	BfBackfilledJobs       float64 `json:"bf_backfilled_jobs"`
+	BfLastBackfilledJobs   float64 `json:"bf_last_backfilled_jobs"`
+	BfBackfilledHetJobs    float64 `json:"bf_backfilled_het_jobs"`
+}
+
+// Responses of the endpoints, the Slurm commands print the same JSON
+// documents with --json
+type nodesResponse struct {
+	Nodes []restNode `json:"nodes"`
+}
+
+type jobsResponse struct {
+	Jobs []restJob `json:"jobs"`
+}
+
+type diagResponse struct {
+	Statistics restDiag `json:"statistics"`
+}
+
+func (r *nodesResponse) nodes() []Node {
+	nodes := []Node{}
+	for _, n := range r.Nodes {
+		nodes = append(nodes, n.node())
+	}
+	return nodes
+}
+
+func (r *jobsResponse) jobs() []Job {
+	jobs := []Job{}
+	for _, j := range r.Jobs {
+		job := j.job()
+		if j.ArrayTaskString == "" {
+			jobs = append(jobs, job)
+			continue
+		}
+		// one job per pending task, as listed by squeue -r
+		for _, task := range arrayTasks(j.ArrayTaskString) {
+			t := job
+			t.ID = fmt.Sprintf("%d_%d", j.JobID, task)
+			jobs = append(jobs, t)
+		}
+	}
+	return jobs
+}
+
+// Task IDs of a job array, e.g. 1-5:2,8%2 for the tasks 1, 3, 5 and 8
+// with at most 2 running at the same time. An invalid list is counted
+// as a parse error and as a single task.
+func arrayTasks(s string) []int64 {
+	if i := strings.Index(s, "%"); i >= 0 {
+		s = s[:i]
+	}
+	tasks := []int64{}
+	for _, r := range strings.Split(s, ",") {
+		step := int64(1)
+		if i := strings.Index(r, ":"); i >= 0 {
+			var err error
+			if step, err = strconv.ParseInt(r[i+1:], 10, 64); err != nil || step < 1 {
+				parseError("ParseJobsJSON", "array_task_string="+s)
+				return []int64{0}
+			}
+			r = r[:i]
+		}
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.ParseInt(bounds[0], 10, 64)
+		last := first
+		if err == nil && len(bounds) == 2 {
+			last, err = strconv.ParseInt(bounds[1], 10, 64)
+		}
+		if err != nil || last < first {
+			parseError("ParseJobsJSON", "array_task_string="+s)
+			return []int64{0}
+		}
+		for task := first; task <= last; task += step {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+func (s *RESTSource) Nodes(ctx context.Context) ([]Node, error) {
+	var resp nodesResponse
+	if err := s.get(ctx, "nodes", &resp); err != nil {
+		return nil, err
+	}
+	return resp.nodes(), nil
+}
+
+func (s *RESTSource) Jobs(ctx context.Context) ([]Job, error) {
+	var resp jobsResponse
+	if err := s.get(ctx, "jobs", &resp); err != nil {
+		return nil, err
+	}
+	return resp.jobs(), nil
+}
+
+func (s *RESTSource) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	var resp diagResponse
+	if err := s.get(ctx, "diag", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Statistics.metrics(), nil
+}
+
+// Slurm prints NO_VAL, or the larger NO_VAL64, for values a node does not
+// report
+const noVal = 4294967294
+
+// Convert to the node model shared with the command parsers
+func (n *restNode) node() Node {
+	state, flags := parseNodeState(strings.Join(append([]string{n.State}, n.StateFlags...), "+"))
+	node := Node{
+		Name:           n.Name,
+		Partitions:     n.Partitions,
+		State:          state,
+		Flags:          flags,
+		CPUAlloc:       n.AllocCPUs,
+		CPUTotal:       n.CPUs,
+		CPUEffective:   n.EffectiveCPUs,
+		CPULoad:        n.CPULoad / 100,
+		HasCPULoad:     n.CPULoad < noVal,
+		RealMemory:     n.RealMemory,
+		AllocMemory:    n.AllocMemory,
+		FreeMemory:     n.FreeMemory,
+		HasFreeMemory:  n.FreeMemory < noVal,
+		Gres:           n.Gres,
+		GresUsed:       n.GresUsed,
+		CfgTRES:        n.TRES,
+		AllocTRES:      n.TRESUsed,
+		Features:       nodeAttribute(n.Features),
+		ActiveFeatures: nodeAttribute(n.ActiveFeatures),
+		Weight:         strconv.FormatFloat(n.Weight, 'f', -1, 64),
+		Arch:           nodeAttribute(n.Arch),
+		OS:             nodeAttribute(n.OS),
+		Reason:         n.Reason,
+		ReasonUser:     n.ReasonUser,
+	}
+	if n.ReasonTime > 0 {
+		node.ReasonTime = time.Unix(n.ReasonTime, 0)
+	}
+	return node
+}
+
+// Convert to the job model shared with the command parsers, the memory
+// is the minimum requested like squeue %m
+func (j *restJob) job() Job {
+	memory := j.MemoryPerNode
+	if memory == 0 {
+		memory = j.MemoryPerCPU
+	}
+	return Job{
+		ID:         strconv.FormatInt(j.JobID, 10),
+		User:       j.UserName,
+		Account:    j.Account,
+		Partitions: strings.Split(j.Partition, ","),
+		State:      strings.ToUpper(j.JobState),
+		CPUs:       j.CPUs,
+		Memory:     memory,
+		Reason:     j.StateReason,
+	}
+}
+
+func (d *restDiag) metrics() *SchedulerMetrics {
+	return &SchedulerMetrics{
+		threads:                           d.ServerThreadCount,
+		queue_size:                        d.AgentQueueSize,
+		dbd_queue_size:                    d.DBDAgentQueueSize,
+		last_cycle:                        d.ScheduleCycleLast,
+		mean_cycle:                        d.ScheduleCycleMean,
+		cycle_per_minute:                  d.ScheduleCyclePerMinute,
+		backfill_last_cycle:               d.BfCycleLast,
+		backfill_mean_cycle:               d.BfCycleMean,
+		backfill_depth_mean:               d.BfDepthMean,
+		total_backfilled_jobs_since_start: d.BfBackfilledJobs,
+		total_backfilled_jobs_since_cycle: d.BfLastBackfilledJobs,
+		total_backfilled_heterogeneous:    d.BfBackfilledHetJobs,
+	}
+}
@@ -0,0 +1,163 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Serves the JSON responses of slurmrestd recorded in test_data/slurmrestd
+func restServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-SLURM-USER-NAME") != "slurm" || r.Header.Get("X-SLURM-USER-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors": [{"error": "authentication failed", "errno": 1007}]}`))
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/slurm/v0.0.37/") {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "test_data/slurmrestd/"+path.Base(r.URL.Path)+".json")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestRESTSource(t *testing.T) *RESTSource {
+	s := NewRESTSource(restServer(t).URL, "v0.0.37", time.Second)
+	s.User = "slurm"
+	s.Token = "secret"
+	return s
+}
+
+// Replace the source used by the collectors for the duration of a test
+func useSource(t *testing.T, s DataSource) {
+	old := source
+	source = s
+	t.Cleanup(func() { source = old })
+}
+
+func TestRESTNodes(t *testing.T) {
+	nodes, err := newTestRESTSource(t).Nodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 {
+		t.Fatalf("Expected 4 nodes, got %d", len(nodes))
+	}
+	node := nodes[1]
+	if node.Name != "milton-gpu-002" || node.StateLong() != "drained*" || node.CPULoad != 0.72 ||
+		node.Weight != "1000" || node.Gres != "gpu:V100:4(S:0-1)" ||
+		node.Reason != "Kill task failed" || node.ReasonUser != "root" || node.ReasonTime.Unix() != 1604308467 ||
+		node.Arch != "x86_64" || !strings.HasPrefix(node.OS, "Linux 3.10.0") {
+		t.Errorf("Unexpected node %+v", node)
+	}
+	if nodes[0].CfgTRES != "cpu=48,mem=105251M,billing=48,gres/gpu=4" || nodes[0].AllocTRES != "cpu=41,mem=41984M,gres/gpu=1" {
+		t.Errorf("Unexpected TRES %q and %q", nodes[0].CfgTRES, nodes[0].AllocTRES)
+	}
+	if alloc, idle, other := nodes[3].CPUs(); alloc != 0 || idle != 0 || other != 56 {
+		t.Errorf("Expected the CPUs of the down node to be other, got %v %v %v", alloc, idle, other)
+	}
+}
+
+func TestRESTJobs(t *testing.T) {
+	jobs, err := newTestRESTSource(t).Jobs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 7 {
+		t.Fatalf("Expected 3 jobs and 4 pending array tasks, got %d", len(jobs))
+	}
+	if jobs[0].ID != "1017242" || jobs[0].State != "PENDING" || jobs[0].Memory != 92160 {
+		t.Errorf("Unexpected job %+v", jobs[0])
+	}
+	if jobs[1].Memory != 4000 || len(jobs[2].Partitions) != 2 {
+		t.Errorf("Unexpected jobs %+v %+v", jobs[1], jobs[2])
+	}
+	// the pending array is counted by task like squeue -r
+	ids := []string{}
+	for _, job := range jobs[3:] {
+		if job.State != "PENDING" || job.User != "bedo.j" {
+			t.Errorf("Unexpected array task %+v", job)
+		}
+		ids = append(ids, job.ID)
+	}
+	if strings.Join(ids, " ") != "1017400_1 1017400_3 1017400_5 1017400_8" {
+		t.Errorf("Unexpected array tasks %v", ids)
+	}
+}
+
+func TestArrayTasks(t *testing.T) {
+	for input, expected := range map[string]int{"1-10": 10, "1-10%2": 10, "3,5,7-9": 5, "0-9:3": 4, "x-1": 1} {
+		if tasks := arrayTasks(input); len(tasks) != expected {
+			t.Errorf("%q: expected %d tasks, got %v", input, expected, tasks)
+		}
+	}
+}
+
+func TestRESTDiag(t *testing.T) {
+	sm, err := newTestRESTSource(t).Diag(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.threads != 3 || sm.last_cycle != 97 || sm.total_backfilled_jobs_since_start != 1456 {
+		t.Errorf("Unexpected statistics %+v", sm)
+	}
+}
+
+func TestRESTAuthentication(t *testing.T) {
+	s := newTestRESTSource(t)
+	s.Token = "wrong"
+	_, err := s.Nodes(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("Expected the error of slurmrestd, got %v", err)
+	}
+}
+
+// All collectors succeed on the slurmrestd data
+func TestRESTAllCollectors(t *testing.T) {
+	useSource(t, newTestRESTSource(t))
+	useRunner(t, fakeRunner{})
+	names := []string{}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewSlurmCollector(names))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "slurm_exporter_collector_success" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			if m.GetGauge().GetValue() != 1 {
+				t.Errorf("Collector %s failed", m.Label[0].GetValue())
+			}
+		}
+	}
+}
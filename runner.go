@@ -0,0 +1,194 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ * All Slurm commands are executed through a CommandRunner. Collectors
+ * never call exec directly, so a failing or hanging command is returned
+ * as an error instead of terminating the exporter, and tests can replace
+ * the runner with one serving canned output.
+ */
+
+// CommandRunner executes a command and returns its standard output
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// CommandError describes a command which could not be executed, exited
+// with a non-zero status or did not finish before its deadline
+type CommandError struct {
+	Command  string
+	Args     []string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Command, strings.Join(e.Args, " "), e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ExecRunner runs commands on the local host, killing them once the
+// timeout has expired
+type ExecRunner struct {
+	Timeout  time.Duration
+	Paths    map[string]string        // path of a command if not found in $PATH
+	Timeouts map[string]time.Duration // timeout of a command if not Timeout
+	Env      []string                 // added to the environment of the exporter
+}
+
+// NewExecRunner returns a runner executing local commands with the given
+// timeout, zero disables the timeout
+func NewExecRunner(timeout time.Duration) *ExecRunner {
+	return &ExecRunner{Timeout: timeout}
+}
+
+func (r *ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	timeout := r.Timeout
+	if t, ok := r.Timeouts[name]; ok && t > 0 {
+		timeout = t
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	path := name
+	if p, ok := r.Paths[name]; ok && p != "" {
+		path = p
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	// the slurm.conf of a cluster takes precedence over the default one
+	env := append(r.Env, clusterFromContext(ctx).env()...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		cerr := &CommandError{
+			Command:  name,
+			Args:     args,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
+		if ctx.Err() != nil {
+			cerr.Err = ctx.Err()
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			cerr.ExitCode = exitErr.ExitCode()
+		}
+		return nil, cerr
+	}
+	return stdout.Bytes(), nil
+}
+
+// Execution time and exit code of the commands
+var (
+	commandDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "slurm_exporter_command_duration_seconds",
+			Help:    "Execution time of the Slurm commands",
+			Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"command", "cluster"})
+	commandExecutions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "slurm_exporter_command_executions_total",
+			Help: "Number of executed Slurm commands by exit code, -1 if the command could not be started or was killed",
+		},
+		[]string{"command", "cluster", "exit_code"})
+)
+
+// InstrumentedRunner records and logs the execution time and exit code of
+// every command run by another runner
+type InstrumentedRunner struct {
+	runner CommandRunner
+}
+
+func NewInstrumentedRunner(runner CommandRunner) *InstrumentedRunner {
+	return &InstrumentedRunner{runner: runner}
+}
+
+func (r *InstrumentedRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	start := time.Now()
+	out, err := r.runner.Run(ctx, name, args...)
+	duration := time.Since(start)
+	cluster := clusterFromContext(ctx).label()
+	commandDuration.WithLabelValues(name, cluster).Observe(duration.Seconds())
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if cerr, ok := err.(*CommandError); ok {
+			exitCode = cerr.ExitCode
+		}
+	}
+	commandExecutions.WithLabelValues(name, cluster, strconv.Itoa(exitCode)).Inc()
+	level.Debug(logger).Log("msg", "Executed Slurm command",
+		"argv", strings.Join(append([]string{name}, args...), " "),
+		"duration", duration, "exit_code", exitCode)
+	return out, err
+}
+
+// Runner used by all collectors, replaced in main() once the flags are
+// parsed and when the configuration is reloaded
+var (
+	runner   CommandRunner = NewExecRunner(30 * time.Second)
+	runnerMu sync.RWMutex
+)
+
+// Replace the runner while collections may be running
+func setRunner(r CommandRunner) {
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	runner = r
+}
+
+// Execute a Slurm command through the configured runner, the context
+// cancels the command and selects the cluster
+func RunCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	runnerMu.RLock()
+	r := runner
+	runnerMu.RUnlock()
+	out, err := r.Run(ctx, name, clusterFromContext(ctx).args(args)...)
+	recordHealth(ctx, name, err)
+	return out, err
+}
@@ -0,0 +1,116 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Serves the content of a test data file for each command name
+type fakeRunner map[string]string
+
+func (f fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	file, ok := f[name]
+	if !ok {
+		return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: exec.ErrNotFound}
+	}
+	return ioutil.ReadFile(file)
+}
+
+// Replace the runner used by the collectors for the duration of a test
+func useRunner(t *testing.T, r CommandRunner) {
+	old := runner
+	runner = r
+	t.Cleanup(func() { runner = old })
+}
+
+func TestExecRunner(t *testing.T) {
+	out, err := NewExecRunner(time.Second).Run(context.Background(), "sh", "-c", "echo 42")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "42\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestExecRunnerExitCode(t *testing.T) {
+	_, err := NewExecRunner(time.Second).Run(context.Background(), "sh", "-c", "echo failed >&2; exit 3")
+	var cerr *CommandError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected a CommandError, got %v", err)
+	}
+	if cerr.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", cerr.ExitCode)
+	}
+	if cerr.Stderr != "failed" {
+		t.Errorf("Expected stderr to be captured, got %q", cerr.Stderr)
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := NewExecRunner(100*time.Millisecond).Run(context.Background(), "sleep", "10")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Command was not killed after its timeout")
+	}
+}
+
+func TestGetMetricsCommandFailure(t *testing.T) {
+	useRunner(t, fakeRunner{})
+	if _, err := SchedulerGetMetrics(context.Background()); err == nil {
+		t.Errorf("Expected an error when sdiag can not be executed")
+	}
+}
+
+func TestInstrumentedRunner(t *testing.T) {
+	r := NewInstrumentedRunner(NewExecRunner(time.Second))
+	before := testutil.ToFloat64(commandExecutions.WithLabelValues("sh", "", "3"))
+	r.Run(context.Background(), "sh", "-c", "exit 3")
+	if after := testutil.ToFloat64(commandExecutions.WithLabelValues("sh", "", "3")); after != before+1 {
+		t.Errorf("Expected the exit code to be counted, got %v", after-before)
+	}
+}
+
+func TestInstrumentedRunnerLog(t *testing.T) {
+	buf := useLogger(t, level.AllowDebug())
+	r := NewInstrumentedRunner(NewExecRunner(time.Second))
+	r.Run(context.Background(), "sh", "-c", "exit 3")
+	out := buf.String()
+	for _, want := range []string{`level=debug`, `argv="sh -c exit 3"`, `exit_code=3`, `duration=`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the log, got %s", want, out)
+		}
+	}
+	buf.Reset()
+	logger = level.NewFilter(logger, level.AllowInfo())
+	r.Run(context.Background(), "true")
+	if buf.Len() > 0 {
+		t.Errorf("Expected no command log above debug, got %s", buf.String())
+	}
+}
@@ -16,15 +16,16 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
+	"context"
 	"github.com/prometheus/client_golang/prometheus"
-	"io/ioutil"
-	"log"
-	"os/exec"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
+func init() {
+	registerCollector("scheduler", true, func() Collector { return NewSchedulerCollector() })
+}
+
 /*
  * Execute the Slurm sdiag command to read the current statistics
  * from the Slurm scheduler. It will be repreatedly called by the
@@ -48,20 +49,8 @@ type SchedulerMetrics struct {
 }
 
 // Execute the sdiag command and return its output
-func SchedulerData() []byte {
-	cmd := exec.Command("sdiag")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
+func SchedulerData(ctx context.Context) ([]byte, error) {
+	return RunCommand(ctx, "sdiag")
 }
 
 // Extract the relevant metrics from the sdiag output
@@ -87,37 +76,37 @@ func ParseSchedulerMetrics(input []byte) *SchedulerMetrics {
 			tbh := regexp.MustCompile(`^[\s]+Total backfilled heterogeneous job components`)
 			switch {
 			case st.MatchString(state) == true:
-				sm.threads, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.threads = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case qs.MatchString(state) == true:
-				sm.queue_size, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.queue_size = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case dbd.MatchString(state) == true:
-				sm.dbd_queue_size, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.dbd_queue_size = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case lc.MatchString(state) == true:
 				if lc_count == 0 {
-					sm.last_cycle, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+					sm.last_cycle = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 					lc_count = 1
 				}
 				if lc_count == 1 {
-					sm.backfill_last_cycle, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+					sm.backfill_last_cycle = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 				}
 			case mc.MatchString(state) == true:
 				if mc_count == 0 {
-					sm.mean_cycle, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+					sm.mean_cycle = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 					mc_count = 1
 				}
 				if mc_count == 1 {
-					sm.backfill_mean_cycle, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+					sm.backfill_mean_cycle = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 				}
 			case cpm.MatchString(state) == true:
-				sm.cycle_per_minute, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.cycle_per_minute = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case dpm.MatchString(state) == true:
-				sm.backfill_depth_mean, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.backfill_depth_mean = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case tbs.MatchString(state) == true:
-				sm.total_backfilled_jobs_since_start, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.total_backfilled_jobs_since_start = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case tbc.MatchString(state) == true:
-				sm.total_backfilled_jobs_since_cycle, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.total_backfilled_jobs_since_cycle = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			case tbh.MatchString(state) == true:
-				sm.total_backfilled_heterogeneous, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(line, ":")[1]), 64)
+				sm.total_backfilled_heterogeneous = parseFloat("ParseSchedulerMetrics", strings.Split(line, ":")[1])
 			}
 		}
 	}
@@ -125,8 +114,12 @@ func ParseSchedulerMetrics(input []byte) *SchedulerMetrics {
 }
 
 // Returns the scheduler metrics
-func SchedulerGetMetrics() *SchedulerMetrics {
-	return ParseSchedulerMetrics(SchedulerData())
+func SchedulerGetMetrics(ctx context.Context) (*SchedulerMetrics, error) {
+	data, err := SchedulerData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedulerMetrics(data), nil
 }
 
 /*
@@ -168,8 +161,11 @@ func (c *SchedulerCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // Send the values of all metrics
-func (sc *SchedulerCollector) Collect(ch chan<- prometheus.Metric) {
-	sm := SchedulerGetMetrics()
+func (sc *SchedulerCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	sm, err := scrape.Diag()
+	if err != nil {
+		return err
+	}
 	ch <- prometheus.MustNewConstMetric(sc.threads, prometheus.GaugeValue, sm.threads)
 	ch <- prometheus.MustNewConstMetric(sc.queue_size, prometheus.GaugeValue, sm.queue_size)
 	ch <- prometheus.MustNewConstMetric(sc.dbd_queue_size, prometheus.GaugeValue, sm.dbd_queue_size)
@@ -182,6 +178,7 @@ func (sc *SchedulerCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_start, prometheus.GaugeValue, sm.total_backfilled_jobs_since_start)
 	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_jobs_since_cycle, prometheus.GaugeValue, sm.total_backfilled_jobs_since_cycle)
 	ch <- prometheus.MustNewConstMetric(sc.total_backfilled_heterogeneous, prometheus.GaugeValue, sm.total_backfilled_heterogeneous)
+	return nil
 }
 
 // Returns the Slurm scheduler collector, used to register with the prometheus client
@@ -16,6 +16,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -32,5 +33,10 @@ func TestSchedulerMetrics(t *testing.T) {
 }
 
 func TestSchedulerGetMetrics(t *testing.T) {
-	t.Logf("%+v", SchedulerGetMetrics())
+	useRunner(t, fakeRunner{"sdiag": "test_data/sdiag.txt"})
+	metrics, err := SchedulerGetMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Can not get metrics: %v", err)
+	}
+	t.Logf("%+v", metrics)
 }
@@ -0,0 +1,48 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+ * Prometheus sends the scrape timeout with every request. The Slurm
+ * commands of a scrape are killed once it has expired, since Prometheus
+ * has given up on the response by then, and the collectors which did not
+ * finish report a failure instead of their metrics.
+ */
+
+// Header with the scrape timeout in seconds
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// Context of the collections of a request, it ends offset before the
+// scrape timeout or on shutdown. Without a valid timeout only shutdown
+// ends it. An offset larger than the timeout is ignored.
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get(scrapeTimeoutHeader), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(collectContext)
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if offset < timeout {
+		timeout -= offset
+	}
+	return context.WithTimeout(collectContext, timeout)
+}
@@ -0,0 +1,96 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeContext(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		offset time.Duration
+		want   time.Duration // zero without deadline
+	}{
+		{"", time.Second, 0},
+		{"abc", time.Second, 0},
+		{"-1", time.Second, 0},
+		{"10", 500 * time.Millisecond, 9500 * time.Millisecond},
+		{"0.25", 0, 250 * time.Millisecond},
+		{"0.25", time.Second, 250 * time.Millisecond},
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tc.header != "" {
+			r.Header.Set(scrapeTimeoutHeader, tc.header)
+		}
+		start := time.Now()
+		ctx, cancel := scrapeContext(r, tc.offset)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if tc.want == 0 {
+			if ok {
+				t.Errorf("Header %q: expected no deadline", tc.header)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("Header %q: expected a deadline", tc.header)
+			continue
+		}
+		if d := deadline.Sub(start); d < tc.want || d > tc.want+100*time.Millisecond {
+			t.Errorf("Header %q: expected a timeout of %s, got %s", tc.header, tc.want, d)
+		}
+	}
+}
+
+// Blocks every command until its context ends
+type blockingRunner struct{}
+
+func (blockingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, &CommandError{Command: name, Args: args, ExitCode: -1, Err: ctx.Err()}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	useRunner(t, blockingRunner{})
+	e, err := NewExporter(&Config{}, enabledCollectors(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{exporter: e}
+	before := testutil.ToFloat64(collectTimeouts.WithLabelValues("queue", ""))
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set(scrapeTimeoutHeader, "0.2")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	srv.ServeMetrics(w, r)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Expected the scrape to end with its timeout, took %s", d)
+	}
+	if !strings.Contains(w.Body.String(), `slurm_exporter_collector_success{collector="queue"} 0`) {
+		t.Errorf("Expected the queue collector to fail, got:\n%s", w.Body.String())
+	}
+	if after := testutil.ToFloat64(collectTimeouts.WithLabelValues("queue", "")); after != before+1 {
+		t.Errorf("Expected one timeout of the queue collector, got %v", after-before)
+	}
+}
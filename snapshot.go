@@ -0,0 +1,115 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+ * In polling mode the Slurm collectors are not executed by the scrapes.
+ * A SnapshotGatherer refreshes all of them on a fixed interval in the
+ * background and every scrape is answered with the last complete
+ * snapshot, so the load on slurmctld does not depend on the number of
+ * Prometheus servers scraping the exporter.
+ */
+
+// SnapshotGatherer serves the last complete result of a background Gather
+type SnapshotGatherer struct {
+	source   prometheus.Gatherer
+	interval time.Duration
+
+	mu       sync.RWMutex
+	families []*dto.MetricFamily
+	updated  time.Time
+	duration time.Duration
+
+	ageDesc      *prometheus.Desc
+	durationDesc *prometheus.Desc
+}
+
+// NewSnapshotGatherer returns a gatherer refreshing the metrics of source
+// every interval once Run has been started
+func NewSnapshotGatherer(source prometheus.Gatherer, interval time.Duration) *SnapshotGatherer {
+	return &SnapshotGatherer{
+		source:   source,
+		interval: interval,
+		ageDesc: prometheus.NewDesc("slurm_exporter_snapshot_age_seconds",
+			"Age of the metrics snapshot served in polling mode", nil, nil),
+		durationDesc: prometheus.NewDesc("slurm_exporter_snapshot_duration_seconds",
+			"Time it took to collect the metrics snapshot served in polling mode", nil, nil),
+	}
+}
+
+// Run refreshes the snapshot until stop is closed
+func (s *SnapshotGatherer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Refresh()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh gathers all metrics from the source, the previous snapshot is
+// kept if gathering fails
+func (s *SnapshotGatherer) Refresh() {
+	start := time.Now()
+	families, err := s.source.Gather()
+	if err != nil {
+		level.Error(logger).Log("msg", "Metrics snapshot failed, serving the previous one", "err", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.families = families
+	s.updated = time.Now()
+	s.duration = s.updated.Sub(start)
+}
+
+// Gather returns the last snapshot
+func (s *SnapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.families, nil
+}
+
+// Describe and Collect expose the age of the snapshot, they are
+// registered with the live registry and not part of the snapshot
+func (s *SnapshotGatherer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.ageDesc
+	ch <- s.durationDesc
+}
+
+func (s *SnapshotGatherer) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// nothing to report until the first snapshot is complete
+	if s.updated.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(s.ageDesc, prometheus.GaugeValue, time.Since(s.updated).Seconds())
+	ch <- prometheus.MustNewConstMetric(s.durationDesc, prometheus.GaugeValue, s.duration.Seconds())
+}
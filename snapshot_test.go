@@ -0,0 +1,68 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSnapshotGatherer(t *testing.T) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value", Help: "Test value"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge)
+	snapshot := NewSnapshotGatherer(registry, time.Minute)
+
+	families, _ := snapshot.Gather()
+	if len(families) != 0 {
+		t.Fatalf("Expected no metrics before the first refresh, got %d", len(families))
+	}
+	gauge.Set(1)
+	snapshot.Refresh()
+	gauge.Set(2)
+	families, _ = snapshot.Gather()
+	if len(families) != 1 || families[0].Metric[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("Expected the value of the last refresh, got %v", families)
+	}
+	snapshot.Refresh()
+	families, _ = snapshot.Gather()
+	if families[0].Metric[0].GetGauge().GetValue() != 2 {
+		t.Errorf("Expected the refreshed value, got %v", families)
+	}
+}
+
+func TestSnapshotGathererKeepsLastSnapshot(t *testing.T) {
+	fail := false
+	source := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		if fail {
+			return nil, errors.New("gather failed")
+		}
+		name := "test_value"
+		return []*dto.MetricFamily{{Name: &name}}, nil
+	})
+	snapshot := NewSnapshotGatherer(source, time.Minute)
+	snapshot.Refresh()
+	fail = true
+	snapshot.Refresh()
+	families, _ := snapshot.Gather()
+	if len(families) != 1 {
+		t.Errorf("Expected the previous snapshot after a failed refresh, got %v", families)
+	}
+}
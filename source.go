@@ -0,0 +1,138 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+/*
+ * The collectors read the Slurm data from a DataSource. By default the
+ * data is read from the output of the Slurm commands, alternatively it is
+ * requested from the slurmrestd REST API. Partitions are derived from the
+ * node inventory and need no request of their own.
+ */
+
+// DataSource provides the jobs, nodes and scheduler statistics
+type DataSource interface {
+	Jobs(ctx context.Context) ([]Job, error)
+	Nodes(ctx context.Context) ([]Node, error)
+	Diag(ctx context.Context) (*SchedulerMetrics, error)
+}
+
+// CommandSource executes the Slurm commands through the runner and
+// parses their output. With JSON set the commands are run with --json,
+// which Slurm supports since 21.08, the delimited text output is the
+// fallback for older clusters and is used from the first JSON command
+// on which is rejected by Slurm or prints output that can not be parsed.
+type CommandSource struct {
+	json int32
+}
+
+// NewCommandSource returns a source parsing text or JSON output
+func NewCommandSource(json bool) *CommandSource {
+	s := &CommandSource{}
+	if json {
+		s.json = 1
+	}
+	return s
+}
+
+// JSON returns true while the JSON output is used
+func (s *CommandSource) JSON() bool {
+	return atomic.LoadInt32(&s.json) == 1
+}
+
+// Returns true if the error of a JSON command shows that the command does
+// not support --json, i.e. it was executed and exited with an error.
+// Timeouts, cancelled scrapes and an open circuit breaker say nothing
+// about the JSON support and fail the collection instead.
+func rejectsJSON(err error) bool {
+	cerr, ok := err.(*CommandError)
+	if !ok || cerr.ExitCode <= 0 {
+		return false
+	}
+	return cerr.Err != context.DeadlineExceeded && cerr.Err != context.Canceled
+}
+
+// Switch to the text output after a JSON command failed
+func (s *CommandSource) fallback(err error) {
+	if atomic.CompareAndSwapInt32(&s.json, 1, 0) {
+		level.Warn(logger).Log("msg", "Slurm JSON output failed, falling back to text output", "err", err)
+	}
+}
+
+func (s *CommandSource) Jobs(ctx context.Context) ([]Job, error) {
+	if s.JSON() {
+		out, err := JobsJSONData(ctx)
+		if err == nil {
+			var jobs []Job
+			if jobs, err = ParseJobsJSON(out); err == nil {
+				return jobs, nil
+			}
+		} else if !rejectsJSON(err) {
+			return nil, err
+		}
+		s.fallback(err)
+	}
+	out, err := JobsData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobs(out), nil
+}
+
+func (s *CommandSource) Nodes(ctx context.Context) ([]Node, error) {
+	if s.JSON() {
+		out, err := NodesJSONData(ctx)
+		if err == nil {
+			var nodes []Node
+			if nodes, err = ParseNodesJSON(out); err == nil {
+				return nodes, nil
+			}
+		} else if !rejectsJSON(err) {
+			return nil, err
+		}
+		s.fallback(err)
+	}
+	out, err := NodesInventoryData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNodes(out), nil
+}
+
+func (s *CommandSource) Diag(ctx context.Context) (*SchedulerMetrics, error) {
+	if s.JSON() {
+		out, err := SchedulerJSONData(ctx)
+		if err == nil {
+			var sm *SchedulerMetrics
+			if sm, err = ParseSchedulerJSON(out); err == nil {
+				return sm, nil
+			}
+		} else if !rejectsJSON(err) {
+			return nil, err
+		}
+		s.fallback(err)
+	}
+	return SchedulerGetMetrics(ctx)
+}
+
+// Source used by all collectors, replaced in main() once the flags are parsed
+var source DataSource = NewCommandSource(false)
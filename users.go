@@ -16,30 +16,13 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>. */
 package main
 
 import (
-	"io/ioutil"
-	"log"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func UsersData() []byte {
-	cmd := exec.Command("squeue", "-a", "-r", "-h", "-o %A|%u|%T|%C|%m|%r")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	out, _ := ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-	return out
+func init() {
+	registerCollector("users", true, func() Collector { return NewUsersCollector() })
 }
 
 /*UserJobMetrics struct to collect number of jobs in each state
@@ -60,57 +43,34 @@ type UserJobMetrics struct {
 	reason        string
 }
 
-func ParseUsersMetrics(input []byte) map[string]*UserJobMetrics {
+// Count the jobs, CPUs and memory of each user
+func ParseUsersMetrics(jobs []Job) map[string]*UserJobMetrics {
 	users := make(map[string]*UserJobMetrics)
-	lines := strings.Split(string(input), "\n")
-
-	for _, line := range lines {
-
-		if strings.Contains(line, "|") {
-			user := strings.Split(line, "|")[1]
-			_, key := users[user]
-			if !key {
-				users[user] = &UserJobMetrics{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, ""}
-			}
-			state := strings.Split(line, "|")[2]
-			state = strings.ToLower(state)
-			cpus, _ := strconv.ParseFloat(strings.Split(line, "|")[3], 64)
-			m := strings.Split(line, "|")[4]
-			reason := strings.Split(line, "|")[5]
-			mem := 0.0
-
-			if strings.HasSuffix(m, "G") {
-				m = m[:len(m)-1]
-				mem, _ = strconv.ParseFloat(m, 64)
-				mem *= 1024
-			} else if strings.HasSuffix(m, "M") {
-				m = m[:len(m)-1]
-				mem, _ = strconv.ParseFloat(m, 64)
-			}
-			pending := regexp.MustCompile(`^pending`)
-			running := regexp.MustCompile(`^running`)
-			suspended := regexp.MustCompile(`^suspended`)
-			switch {
-			case pending.MatchString(state) == true:
-				users[user].pending++
-				users[user].pendingCpus += cpus
-				users[user].pendingMem += mem
-				users[user].reason = reason
-				if strings.Contains(reason, "QOS") {
-					users[user].pendingQOS++
-				} else {
-					users[user].pendingOthers++
-				}
-
-			case running.MatchString(state) == true:
-				users[user].running++
-				users[user].runningCpus += cpus
-				users[user].runningMem += mem
-			case suspended.MatchString(state) == true:
-				users[user].suspended++
-				users[user].suspendedCpus += cpus
-				users[user].suspendedMem += mem
+	for _, job := range jobs {
+		user := job.User
+		_, key := users[user]
+		if !key {
+			users[user] = &UserJobMetrics{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, ""}
+		}
+		switch job.State {
+		case "PENDING":
+			users[user].pending++
+			users[user].pendingCpus += job.CPUs
+			users[user].pendingMem += job.Memory
+			users[user].reason = job.Reason
+			if strings.Contains(job.Reason, "QOS") {
+				users[user].pendingQOS++
+			} else {
+				users[user].pendingOthers++
 			}
+		case "RUNNING":
+			users[user].running++
+			users[user].runningCpus += job.CPUs
+			users[user].runningMem += job.Memory
+		case "SUSPENDED":
+			users[user].suspended++
+			users[user].suspendedCpus += job.CPUs
+			users[user].suspendedMem += job.Memory
 		}
 	}
 	return users
@@ -162,8 +122,12 @@ func (uc *UsersCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- uc.suspendedMem
 }
 
-func (uc *UsersCollector) Collect(ch chan<- prometheus.Metric) {
-	um := ParseUsersMetrics(UsersData())
+func (uc *UsersCollector) Update(ch chan<- prometheus.Metric, scrape *ScrapeData) error {
+	jobs, err := scrape.Jobs()
+	if err != nil {
+		return err
+	}
+	um := ParseUsersMetrics(jobs)
 	for u := range um {
 		if um[u].pending > 0 {
 			ch <- prometheus.MustNewConstMetric(uc.pending, prometheus.GaugeValue, um[u].pending, u)
@@ -199,4 +163,5 @@ func (uc *UsersCollector) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(uc.suspendedMem, prometheus.GaugeValue, um[u].suspendedMem, u)
 		}
 	}
+	return nil
 }
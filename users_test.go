@@ -1,24 +1,18 @@
 package main
 
 import (
-	"io/ioutil"
-	"os"
 	"testing"
 )
 
 func TestParseUsersMetrics(t *testing.T) {
-	// Read the input data from a file
-	file, err := os.Open("test_data/users.txt")
-	if err != nil {
-		t.Fatalf("Can not open test data: %v", err)
-	}
-	data, err := ioutil.ReadAll(file)
-	//t.Error(data)
-	metrics := ParseUsersMetrics(data)
+	metrics := ParseUsersMetrics(readJobs(t))
 	for k, v := range metrics {
-		t.Error(k, v)
 		t.Log(k, v)
 	}
-	//t.Logf("%+v", ParseNodesInfoMetrics(data))
-
+	if metrics["bedo.j"].pendingQOS != 43 {
+		t.Errorf("Expected 43 jobs pending on QOS, got %v", metrics["bedo.j"].pendingQOS)
+	}
+	if metrics["fearnley.l"].pendingOthers != 2 {
+		t.Errorf("Expected 2 jobs pending on other reasons, got %v", metrics["fearnley.l"].pendingOthers)
+	}
 }
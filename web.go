@@ -0,0 +1,333 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+ * The HTTP server is configured with a web configuration file in the
+ * format of the Prometheus exporter-toolkit: TLS with an optional client
+ * certificate verification and basic authentication with bcrypt hashed
+ * passwords. As an extension bearer tokens are accepted, also bcrypt
+ * hashed.
+ */
+
+// WebConfig of the HTTP server
+type WebConfig struct {
+	TLSConfig    TLSServerConfig   `yaml:"tls_server_config"`
+	HTTPConfig   HTTPServerConfig  `yaml:"http_server_config"`
+	Users        map[string]string `yaml:"basic_auth_users"`
+	BearerTokens []string          `yaml:"bearer_tokens"`
+
+	// credentials checked successfully, bcrypt is too slow for every request
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]bool
+}
+
+// TLSServerConfig with the keys of the exporter-toolkit
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+	MaxVersion     string `yaml:"max_version"`
+	// names of the Go crypto/tls package, e.g. TLS_AES_128_GCM_SHA256
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+	// DNS names, email addresses, IP addresses or URIs of which one must be
+	// in the verified client certificate
+	ClientAllowedSANs []string `yaml:"client_allowed_sans"`
+}
+
+// HTTPServerConfig with the keys of the exporter-toolkit
+type HTTPServerConfig struct {
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// Cipher suite by name, insecure ones included as in the exporter-toolkit
+func cipherSuite(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// LoadWebConfig reads and validates the web configuration file
+func LoadWebConfig(filename string) (*WebConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &WebConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("invalid web configuration file %s: %v", filename, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid web configuration file %s: %v", filename, err)
+	}
+	return c, nil
+}
+
+func (c *WebConfig) validate() error {
+	t := c.TLSConfig
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls_server_config: cert_file and key_file are both required")
+	}
+	if t.CertFile == "" && (t.ClientAuthType != "" || t.ClientCAFile != "") {
+		return fmt.Errorf("tls_server_config: client certificates require cert_file and key_file")
+	}
+	auth, ok := clientAuthTypes[t.ClientAuthType]
+	if !ok {
+		return fmt.Errorf("tls_server_config: unknown client_auth_type %q", t.ClientAuthType)
+	}
+	if (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && t.ClientCAFile == "" {
+		return fmt.Errorf("tls_server_config: client_auth_type %s requires client_ca_file", t.ClientAuthType)
+	}
+	for _, v := range []string{t.MinVersion, t.MaxVersion} {
+		if _, ok := tlsVersions[v]; v != "" && !ok {
+			return fmt.Errorf("tls_server_config: unknown TLS version %q", v)
+		}
+	}
+	for _, name := range t.CipherSuites {
+		if _, ok := cipherSuite(name); !ok {
+			return fmt.Errorf("tls_server_config: unknown cipher suite %q", name)
+		}
+	}
+	for _, name := range t.CurvePreferences {
+		if _, ok := curves[name]; !ok {
+			return fmt.Errorf("tls_server_config: unknown curve %q", name)
+		}
+	}
+	if len(t.ClientAllowedSANs) > 0 && t.ClientCAFile == "" {
+		return fmt.Errorf("tls_server_config: client_allowed_sans requires client_ca_file")
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic_auth_users: %s: invalid bcrypt hash: %v", user, err)
+		}
+	}
+	for _, hash := range c.BearerTokens {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("bearer_tokens: invalid bcrypt hash: %v", err)
+		}
+	}
+	return nil
+}
+
+// TLS is enabled by a server certificate
+func (c *WebConfig) tlsEnabled() bool {
+	return c.TLSConfig.CertFile != ""
+}
+
+// Server TLS configuration, the certificates are read at startup
+func (c *WebConfig) serverTLSConfig() (*tls.Config, error) {
+	t := c.TLSConfig
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load the server certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		ClientAuth:               clientAuthTypes[t.ClientAuthType],
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: t.PreferServerCipherSuites,
+	}
+	for _, name := range t.CipherSuites {
+		id, _ := cipherSuite(name)
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range t.CurvePreferences {
+		cfg.CurvePreferences = append(cfg.CurvePreferences, curves[name])
+	}
+	if len(t.ClientAllowedSANs) > 0 {
+		cfg.VerifyPeerCertificate = t.verifySANs
+	}
+	if t.MinVersion != "" {
+		cfg.MinVersion = tlsVersions[t.MinVersion]
+	}
+	if t.MaxVersion != "" {
+		cfg.MaxVersion = tlsVersions[t.MaxVersion]
+	}
+	if t.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read the client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in the client CA file %s", t.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// Accept a verified client certificate only if one of its subject
+// alternative names is allowed
+func (t TLSServerConfig) verifySANs(raw [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return fmt.Errorf("no verified client certificate")
+	}
+	cert := chains[0][0]
+	sans := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, allowed := range t.ClientAllowedSANs {
+		for _, san := range sans {
+			if san == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate has no allowed subject alternative name")
+}
+
+// Check a secret against a bcrypt hash, successful checks are cached
+func (c *WebConfig) check(hash string, secret string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + secret))
+	c.mu.Lock()
+	ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[[sha256.Size]byte]bool{}
+	}
+	c.cache[key] = true
+	c.mu.Unlock()
+	return true
+}
+
+// Returns true if the request carries valid credentials
+func (c *WebConfig) authorized(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, found := c.Users[user]
+		if !found {
+			// compare anyway, so unknown users take as long as known ones
+			hash = "$2a$10$ZA9ldTUJcNQr/XyWOOJVwOmVygGPLshLgadbT5AsnN/DfJAi5sBAS"
+		}
+		return c.check(hash, password) && found
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		for _, hash := range c.BearerTokens {
+			if c.check(hash, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handler adds the configured headers and checks the credentials of all
+// requests if users or tokens are configured
+func (c *WebConfig) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range c.HTTPConfig.Headers {
+			w.Header().Set(k, v)
+		}
+		if len(c.Users) > 0 || len(c.BearerTokens) > 0 {
+			if !c.authorized(r) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="slurm-exporter"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves the handler with the web configuration, plain
+// HTTP without configuration file
+func ListenAndServe(server *http.Server, c *WebConfig) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(server, listener, c)
+}
+
+// Serve accepts the connections of the listener with the web configuration
+func Serve(server *http.Server, listener net.Listener, c *WebConfig) error {
+	if c == nil {
+		return server.Serve(listener)
+	}
+	server.Handler = c.Handler(server.Handler)
+	if c.HTTPConfig.HTTP2 != nil && !*c.HTTPConfig.HTTP2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	if !c.tlsEnabled() {
+		return server.Serve(listener)
+	}
+	cfg, err := c.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = cfg
+	return server.ServeTLS(listener, "", "")
+}
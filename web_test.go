@@ -0,0 +1,228 @@
+/* Copyright 2020 Victor Penso, Matteo Dessalvi
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Write a self-signed certificate for 127.0.0.1, usable by servers and
+// clients, and return the paths of the certificate and key
+func writeCertificate(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+// Start a server with the web configuration and return its address
+func startWebServer(t *testing.T, config string) string {
+	file := filepath.Join(t.TempDir(), "web.yml")
+	ioutil.WriteFile(file, []byte(config), 0600)
+	c, err := LoadWebConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("slurm"))
+	})}
+	go Serve(server, listener, c)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func hash(t *testing.T, secret string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func TestWebAuthentication(t *testing.T) {
+	addr := startWebServer(t, `
+basic_auth_users:
+  prometheus: `+hash(t, "secret")+`
+bearer_tokens:
+  - `+hash(t, "token")+`
+`)
+	for _, test := range []struct {
+		user, password, token string
+		status                int
+	}{
+		{"", "", "", http.StatusUnauthorized},
+		{"prometheus", "secret", "", http.StatusOK},
+		{"prometheus", "wrong", "", http.StatusUnauthorized},
+		{"grafana", "secret", "", http.StatusUnauthorized},
+		{"", "", "token", http.StatusOK},
+		{"", "", "wrong", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/metrics", nil)
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("Expected status %d for %+v, got %d", test.status, test, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeCertificate(t, dir, "server")
+	clientCert, clientKey := writeCertificate(t, dir, "client")
+	addr := startWebServer(t, `
+tls_server_config:
+  cert_file: `+serverCert+`
+  key_file: `+serverKey+`
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: `+clientCert+`
+`)
+	pem, _ := ioutil.ReadFile(serverCert)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+	}}}
+	resp, err := client.Get("https://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "slurm" {
+		t.Errorf("Unexpected response %q", body)
+	}
+	// without client certificate the handshake fails
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if resp, err := client.Get("https://" + addr + "/metrics"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected the request without client certificate to fail")
+	}
+}
+
+func TestWebConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"tls_server_config: {cert_file: a.crt}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, client_auth_type: Verify}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, client_auth_type: RequireAndVerifyClientCert}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, min_version: SSL3}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, cipher_suites: [TLS_NULL]}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, curve_preferences: [P256]}",
+		"tls_server_config: {cert_file: a.crt, key_file: a.key, client_allowed_sans: [127.0.0.1]}",
+		"basic_auth_users: {prometheus: secret}",
+		"listen_address: :8080",
+	} {
+		file := filepath.Join(t.TempDir(), "web.yml")
+		ioutil.WriteFile(file, []byte(config), 0600)
+		if _, err := LoadWebConfig(file); err == nil {
+			t.Errorf("Expected an error for %q", config)
+		}
+	}
+}
+
+func TestWebClientAllowedSANs(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeCertificate(t, dir, "server")
+	clientCert, clientKey := writeCertificate(t, dir, "client")
+	pem, _ := ioutil.ReadFile(serverCert)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{cert},
+	}}}
+	for san, allowed := range map[string]bool{"127.0.0.1": true, "prometheus.example.com": false} {
+		addr := startWebServer(t, `
+tls_server_config:
+  cert_file: `+serverCert+`
+  key_file: `+serverKey+`
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: `+clientCert+`
+  client_allowed_sans: [`+san+`]
+  cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256]
+  curve_preferences: [CurveP256]
+  prefer_server_cipher_suites: true
+`)
+		resp, err := client.Get("https://" + addr + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != allowed {
+			t.Errorf("Expected the request with allowed SAN %s to succeed: %v, got %v", san, allowed, err)
+		}
+	}
+}